@@ -14,6 +14,7 @@ require (
 	github.com/onedaycat/errors v0.0.0-20190820073936-bb82aee22bc2
 	github.com/plimble/mage v0.0.0-20190819102158-456873499615
 	github.com/rs/zerolog v1.15.0
+	github.com/santhosh-tekuri/jsonschema/v5 v5.2.0
 	github.com/stretchr/testify v1.4.0
 	golang.org/x/net v0.0.0-20190813141303-74dc4d7220e7 // indirect
 	golang.org/x/text v0.3.2 // indirect
@@ -0,0 +1,6 @@
+package logger
+
+// SetRandom replaces the source of the sampling decisions.
+func (l *RequestLogger) SetRandom(random func() float64) {
+    l.random = random
+}
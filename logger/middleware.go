@@ -0,0 +1,281 @@
+package logger
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "math/rand"
+    "reflect"
+    "strings"
+    "time"
+
+    "github.com/aws/aws-lambda-go/lambdacontext"
+    "github.com/onedaycat/errors"
+    "github.com/onedaycat/zamus/zamus"
+)
+
+const redacted = "[REDACTED]"
+
+type ItemFailure struct {
+    Index int               `json:"index"`
+    Error *errors.JSONError `json:"error"`
+}
+
+// Entry is the data logged for one invocation.
+type Entry struct {
+    RequestID  string                 `json:"requestId,omitempty"`
+    Input      interface{}            `json:"input,omitempty"`
+    Output     interface{}            `json:"output,omitempty"`
+    BatchSize  int                    `json:"batchSize,omitempty"`
+    Failures   []*ItemFailure         `json:"failures,omitempty"`
+    Attempts   int                    `json:"attempts"`
+    DurationMS float64                `json:"durationMs"`
+    ErrType    string                 `json:"errType,omitempty"`
+    ErrCode    string                 `json:"errCode,omitempty"`
+    Stacktrace []string               `json:"stacktrace,omitempty"`
+    Tags       map[string]interface{} `json:"tags,omitempty"`
+}
+
+// RequestLogger logs the input and output of every invocation of a Handle.
+type RequestLogger struct {
+    log          Logger
+    maxSize      int
+    sampleRate   float64
+    redact       map[string]struct{}
+    successLevel Level
+    retriedLevel Level
+    failureLevel Level
+    tags         []string
+    random       func() float64
+}
+
+func NewRequestLogger(log Logger) *RequestLogger {
+    return &RequestLogger{
+        log:          log,
+        maxSize:      2048,
+        sampleRate:   1,
+        redact:       make(map[string]struct{}),
+        successLevel: InfoLevel,
+        retriedLevel: WarnLevel,
+        failureLevel: ErrorLevel,
+        random:       rand.Float64,
+    }
+}
+
+// SetMaxSize truncates the logged input and output to size bytes. Zero
+// disables the truncation.
+func (l *RequestLogger) SetMaxSize(size int) {
+    l.maxSize = size
+}
+
+// SetSampleRate logs only this ratio of successful invocations. Retried and
+// failed invocations are always logged.
+func (l *RequestLogger) SetSampleRate(rate float64) {
+    l.sampleRate = rate
+}
+
+// SetRedact hides the value of the given JSON fields, at any depth and
+// regardless of case.
+func (l *RequestLogger) SetRedact(fields ...string) {
+    l.redact = make(map[string]struct{}, len(fields))
+    for _, field := range fields {
+        l.redact[strings.ToLower(field)] = struct{}{}
+    }
+}
+
+// SetTags copies the given zamus.Attrs of the invocation, such as the
+// tenant, into every entry.
+func (l *RequestLogger) SetTags(keys ...string) {
+    l.tags = keys
+}
+
+func (l *RequestLogger) SetLevels(success, retried, failure Level) {
+    l.successLevel = success
+    l.retriedLevel = retried
+    l.failureLevel = failure
+}
+
+func (l *RequestLogger) Middleware(next zamus.InvokeHandler) zamus.InvokeHandler {
+    return func(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+        start := time.Now()
+        result, err := next(ctx, payload)
+
+        entry := &Entry{
+            DurationMS: float64(time.Since(start)) / float64(time.Millisecond),
+        }
+
+        if lc, ok := lambdacontext.FromContext(ctx); ok {
+            entry.RequestID = lc.AwsRequestID
+        }
+
+        if len(l.tags) > 0 {
+            entry.Tags = l.tagsOf(ctx)
+        }
+
+        inv := zamus.InvocationFromContext(ctx)
+        if inv != nil {
+            entry.Attempts = inv.Attempts
+        }
+
+        switch {
+        case err != nil:
+            l.logFailure(entry, payload, inv, err)
+        case entry.Attempts > 1:
+            l.logSuccess(l.retriedLevel, "Invoke succeeded after retry", entry, payload, inv, result)
+        case l.sampleRate >= 1 || l.random() < l.sampleRate:
+            l.logSuccess(l.successLevel, "Invoke succeeded", entry, payload, inv, result)
+        }
+
+        return result, err
+    }
+}
+
+func (l *RequestLogger) tagsOf(ctx context.Context) map[string]interface{} {
+    attrs := zamus.Attrs(ctx)
+    tags := make(map[string]interface{}, len(l.tags))
+    for _, key := range l.tags {
+        if value, ok := attrs.Get(key); ok {
+            tags[key] = value
+        }
+    }
+
+    return tags
+}
+
+func (l *RequestLogger) logSuccess(level Level, msg string, entry *Entry, payload json.RawMessage, inv *zamus.Invocation, result interface{}) {
+    if inv != nil && inv.IsBatch {
+        entry.BatchSize = batchSize(inv.Source)
+        entry.Failures = failures(result)
+    } else {
+        entry.Input = l.format(payload)
+        entry.Output = l.formatValue(result)
+    }
+
+    l.write(level, msg, entry, nil)
+}
+
+func (l *RequestLogger) logFailure(entry *Entry, payload json.RawMessage, inv *zamus.Invocation, err error) {
+    if inv != nil && inv.IsBatch {
+        entry.BatchSize = batchSize(inv.Source)
+    } else {
+        entry.Input = l.format(payload)
+    }
+
+    if xerr, ok := err.(errors.Error); ok {
+        entry.ErrType = xerr.GetType()
+        entry.ErrCode = xerr.GetCode()
+        entry.Stacktrace = xerr.GetStacktrace().Strings()
+    } else {
+        entry.ErrType = zamus.GetErrorType(err)
+    }
+
+    l.write(l.failureLevel, "Invoke failed", entry, err)
+}
+
+func (l *RequestLogger) write(level Level, msg string, entry *Entry, err error) {
+    switch level {
+    case DebugLevel:
+        l.log.Debug(msg, entry)
+    case InfoLevel:
+        l.log.Info(msg, entry)
+    case WarnLevel:
+        l.log.Warn(msg, entry)
+    case ErrorLevel, PanicLevel:
+        if err == nil {
+            err = errors.New(msg)
+        }
+
+        if level == PanicLevel {
+            l.log.Panic(err, entry)
+        } else {
+            l.log.Error(err, entry)
+        }
+    }
+}
+
+func (l *RequestLogger) formatValue(value interface{}) interface{} {
+    if value == nil {
+        return nil
+    }
+
+    data, err := json.Marshal(value)
+    if err != nil {
+        return nil
+    }
+
+    return l.format(data)
+}
+
+// format redacts and truncates a JSON document. It stays embedded as JSON
+// unless it has to be truncated.
+func (l *RequestLogger) format(data json.RawMessage) interface{} {
+    if len(l.redact) > 0 {
+        data = l.redactJSON(data)
+    }
+
+    if l.maxSize > 0 && len(data) > l.maxSize {
+        return string(data[:l.maxSize]) + "..."
+    }
+
+    return data
+}
+
+func (l *RequestLogger) redactJSON(data json.RawMessage) json.RawMessage {
+    var value interface{}
+    dec := json.NewDecoder(bytes.NewReader(data))
+    dec.UseNumber()
+    if err := dec.Decode(&value); err != nil {
+        return data
+    }
+
+    redactedData, err := json.Marshal(l.redactValue(value))
+    if err != nil {
+        return data
+    }
+
+    return redactedData
+}
+
+func (l *RequestLogger) redactValue(value interface{}) interface{} {
+    switch v := value.(type) {
+    case map[string]interface{}:
+        for key, field := range v {
+            if _, ok := l.redact[strings.ToLower(key)]; ok {
+                v[key] = redacted
+                continue
+            }
+            v[key] = l.redactValue(field)
+        }
+    case []interface{}:
+        for i, item := range v {
+            v[i] = l.redactValue(item)
+        }
+    }
+
+    return value
+}
+
+func batchSize(src interface{}) int {
+    v := reflect.ValueOf(src)
+    if v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+        return v.Len()
+    }
+
+    return 0
+}
+
+func failures(result interface{}) []*ItemFailure {
+    results, ok := result.([]*zamus.Result)
+    if !ok {
+        return nil
+    }
+
+    var items []*ItemFailure
+    for i, res := range results {
+        if res != nil && res.Failed() {
+            items = append(items, &ItemFailure{Index: i, Error: res.Error})
+        }
+    }
+
+    return items
+}
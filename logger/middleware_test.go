@@ -0,0 +1,151 @@
+package logger_test
+
+import (
+    "context"
+    "encoding/json"
+    "testing"
+
+    "github.com/onedaycat/errors"
+    "github.com/onedaycat/zamus/logger"
+    "github.com/onedaycat/zamus/zamus"
+    "github.com/onedaycat/zamus/zamus/zamustest"
+    "github.com/stretchr/testify/require"
+)
+
+func entryAt(log *zamustest.Logger, i int) *logger.Entry {
+    return log.Lines()[i].Data[0].(*logger.Entry)
+}
+
+func TestRequestLogger(t *testing.T) {
+    th := &zamustest.Handler{}
+    log := &zamustest.Logger{}
+    rl := logger.NewRequestLogger(log)
+    rl.SetRedact("password")
+    h := zamus.New(th)
+    h.SetRetry(1)
+    h.Use(rl.Middleware)
+    ctx := context.Background()
+
+    t.Run("Success", func(t *testing.T) {
+        log.Reset()
+        th.HandlerFunc = func(ctx context.Context, source interface{}) (interface{}, error) {
+            return map[string]string{"Password": "secret", "name": "a"}, nil
+        }
+
+        _, err := h.Invoke(ctx, []byte(`{"user":{"password":"secret","id":"1"}}`))
+
+        require.NoError(t, err)
+        require.Len(t, log.Lines(), 1)
+        require.Equal(t, logger.InfoLevel, log.Lines()[0].Level)
+        require.Equal(t, "Invoke succeeded", log.Lines()[0].Msg)
+        require.JSONEq(t, `{"user":{"password":"[REDACTED]","id":"1"}}`, string(entryAt(log, 0).Input.(json.RawMessage)))
+        require.JSONEq(t, `{"Password":"[REDACTED]","name":"a"}`, string(entryAt(log, 0).Output.(json.RawMessage)))
+        require.Equal(t, 1, entryAt(log, 0).Attempts)
+    })
+
+    t.Run("Retried success", func(t *testing.T) {
+        log.Reset()
+        called := 0
+        th.HandlerFunc = func(ctx context.Context, source interface{}) (interface{}, error) {
+            called++
+            if called == 1 {
+                return nil, errors.InternalError("code1", "msg1")
+            }
+            return "ok", nil
+        }
+
+        _, err := h.Invoke(ctx, []byte(`{"id":"1"}`))
+
+        require.NoError(t, err)
+        require.Equal(t, logger.WarnLevel, log.Lines()[0].Level)
+        require.Equal(t, 2, entryAt(log, 0).Attempts)
+    })
+
+    t.Run("Failure", func(t *testing.T) {
+        log.Reset()
+        th.HandlerFunc = func(ctx context.Context, source interface{}) (interface{}, error) {
+            return nil, errors.BadRequest("code1", "msg1")
+        }
+
+        _, err := h.Invoke(ctx, []byte(`{"id":"1"}`))
+
+        require.NotNil(t, err)
+        entry := entryAt(log, 0)
+        require.Equal(t, logger.ErrorLevel, log.Lines()[0].Level)
+        require.Equal(t, "code1: msg1", log.Lines()[0].Msg)
+        require.Equal(t, "code1", entry.ErrCode)
+        require.Equal(t, errors.BadRequestType, entry.ErrType)
+        require.NotEmpty(t, entry.Stacktrace)
+    })
+
+    t.Run("Truncate", func(t *testing.T) {
+        log.Reset()
+        rl.SetMaxSize(8)
+        defer rl.SetMaxSize(2048)
+        th.HandlerFunc = func(ctx context.Context, source interface{}) (interface{}, error) {
+            return nil, nil
+        }
+
+        _, err := h.Invoke(ctx, []byte(`{"id":"123456789"}`))
+
+        require.NoError(t, err)
+        require.Equal(t, `{"id":"1...`, entryAt(log, 0).Input)
+        require.Nil(t, entryAt(log, 0).Output)
+    })
+
+    t.Run("Sampling", func(t *testing.T) {
+        log.Reset()
+        rl.SetSampleRate(0.5)
+        rl.SetRandom(func() float64 { return 0.7 })
+        defer rl.SetSampleRate(1)
+
+        _, err := h.Invoke(ctx, []byte(`{"id":"1"}`))
+
+        require.NoError(t, err)
+        require.Empty(t, log.Lines())
+    })
+}
+
+func TestRequestLoggerBatch(t *testing.T) {
+    log := &zamustest.Logger{}
+    rl := logger.NewRequestLogger(log)
+    h := zamus.New(&zamustest.Handler{
+        BatchHandlerFunc: func(ctx context.Context, sources interface{}) (interface{}, error) {
+            return []*zamus.Result{
+                zamus.NewResult("ok", nil),
+                zamus.NewResult(nil, errors.NotFound("code1", "msg1")),
+            }, nil
+        },
+    })
+    h.Use(rl.Middleware)
+
+    _, err := h.Invoke(context.Background(), []byte(`[{"id":"1"},{"id":"2"}]`))
+
+    require.NoError(t, err)
+    entry := entryAt(log, 0)
+    require.Nil(t, entry.Input)
+    require.Nil(t, entry.Output)
+    require.Equal(t, 2, entry.BatchSize)
+    require.Equal(t, []*logger.ItemFailure{{
+        Index: 1,
+        Error: &errors.JSONError{Code: "code1", Message: "msg1", ErrType: errors.NotFoundType},
+    }}, entry.Failures)
+}
+
+func TestRequestLoggerTags(t *testing.T) {
+    log := &zamustest.Logger{}
+    rl := logger.NewRequestLogger(log)
+    rl.SetTags("tenant", "missing")
+    h := zamus.New(&zamustest.Handler{
+        HandlerFunc: func(ctx context.Context, source interface{}) (interface{}, error) {
+            zamus.Attrs(ctx).Set("tenant", "t1")
+            return nil, nil
+        },
+    })
+    h.Use(rl.Middleware)
+
+    _, err := h.Invoke(context.Background(), []byte(`{"id":"1"}`))
+
+    require.NoError(t, err)
+    require.Equal(t, map[string]interface{}{"tenant": "t1"}, entryAt(log, 0).Tags)
+}
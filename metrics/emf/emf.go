@@ -0,0 +1,197 @@
+package emf
+
+import (
+    "encoding/json"
+    "io"
+    "os"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/onedaycat/zamus/metrics"
+)
+
+// maxMetrics is the limit of metrics per EMF object.
+const maxMetrics = 100
+
+type metric struct {
+    name   string
+    unit   metrics.Unit
+    values []float64
+    sum    bool
+}
+
+type group struct {
+    dimensions []metrics.Dimension
+    metrics    map[string]*metric
+    order      []string
+}
+
+// EMF writes CloudWatch Embedded Metric Format objects, one line per set of
+// dimensions, which CloudWatch Logs turns into metrics without an agent.
+type EMF struct {
+    mu         sync.Mutex
+    namespace  string
+    dimensions []metrics.Dimension
+    groups     map[string]*group
+    order      []string
+    out        io.Writer
+    now        func() time.Time
+}
+
+// New uses the function name as default dimension when running in Lambda.
+func New(namespace string) *EMF {
+    e := &EMF{
+        namespace: namespace,
+        groups:    make(map[string]*group),
+        out:       os.Stdout,
+        now:       time.Now,
+    }
+
+    if name := os.Getenv("AWS_LAMBDA_FUNCTION_NAME"); name != "" {
+        e.dimensions = []metrics.Dimension{metrics.Dim("FunctionName", name)}
+    }
+
+    return e
+}
+
+func (e *EMF) SetWriter(out io.Writer) {
+    e.out = out
+}
+
+func (e *EMF) SetDimensions(dimensions ...metrics.Dimension) {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+
+    e.dimensions = dimensions
+}
+
+func (e *EMF) Count(name string, value float64, dimensions ...metrics.Dimension) {
+    e.add(name, metrics.UnitCount, value, true, dimensions)
+}
+
+func (e *EMF) Gauge(name string, value float64, unit metrics.Unit, dimensions ...metrics.Dimension) {
+    e.add(name, unit, value, false, dimensions)
+}
+
+func (e *EMF) Timing(name string, value time.Duration, dimensions ...metrics.Dimension) {
+    e.add(name, metrics.UnitMilliseconds, float64(value)/float64(time.Millisecond), false, dimensions)
+}
+
+func (e *EMF) add(name string, unit metrics.Unit, value float64, sum bool, dimensions []metrics.Dimension) {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+
+    dims := e.merge(dimensions)
+    key := groupKey(dims)
+    g, ok := e.groups[key]
+    if !ok {
+        g = &group{
+            dimensions: dims,
+            metrics:    make(map[string]*metric),
+        }
+        e.groups[key] = g
+        e.order = append(e.order, key)
+    }
+
+    m, ok := g.metrics[name]
+    if !ok {
+        m = &metric{name: name, unit: unit, sum: sum}
+        g.metrics[name] = m
+        g.order = append(g.order, name)
+    }
+
+    if m.sum && len(m.values) > 0 {
+        m.values[0] += value
+        return
+    }
+
+    m.values = append(m.values, value)
+}
+
+func (e *EMF) Flush() {
+    e.mu.Lock()
+    groups := e.groups
+    order := e.order
+    e.groups = make(map[string]*group)
+    e.order = nil
+    e.mu.Unlock()
+
+    timestamp := e.now().UnixNano() / int64(time.Millisecond)
+    for _, key := range order {
+        g := groups[key]
+        for start := 0; start < len(g.order); start += maxMetrics {
+            end := start + maxMetrics
+            if end > len(g.order) {
+                end = len(g.order)
+            }
+
+            data, err := json.Marshal(e.object(g, g.order[start:end], timestamp))
+            if err != nil {
+                continue
+            }
+
+            _, _ = e.out.Write(append(data, '\n'))
+        }
+    }
+}
+
+func (e *EMF) object(g *group, names []string, timestamp int64) map[string]interface{} {
+    obj := make(map[string]interface{}, len(g.dimensions)+len(names)+1)
+    dimNames := make([]string, len(g.dimensions))
+    for i, dim := range g.dimensions {
+        dimNames[i] = dim.Name
+        obj[dim.Name] = dim.Value
+    }
+
+    defs := make([]map[string]string, len(names))
+    for i, name := range names {
+        m := g.metrics[name]
+        defs[i] = map[string]string{"Name": m.name, "Unit": string(m.unit)}
+        if len(m.values) == 1 {
+            obj[m.name] = m.values[0]
+        } else {
+            obj[m.name] = m.values
+        }
+    }
+
+    obj["_aws"] = map[string]interface{}{
+        "Timestamp": timestamp,
+        "CloudWatchMetrics": []map[string]interface{}{{
+            "Namespace":  e.namespace,
+            "Dimensions": [][]string{dimNames},
+            "Metrics":    defs,
+        }},
+    }
+
+    return obj
+}
+
+func (e *EMF) merge(dimensions []metrics.Dimension) []metrics.Dimension {
+    dims := make([]metrics.Dimension, 0, len(e.dimensions)+len(dimensions))
+    index := make(map[string]int, cap(dims))
+    for _, dim := range append(e.dimensions[:len(e.dimensions):len(e.dimensions)], dimensions...) {
+        if i, ok := index[dim.Name]; ok {
+            dims[i] = dim
+            continue
+        }
+        index[dim.Name] = len(dims)
+        dims = append(dims, dim)
+    }
+
+    sort.SliceStable(dims, func(i, j int) bool {
+        return dims[i].Name < dims[j].Name
+    })
+
+    return dims
+}
+
+func groupKey(dimensions []metrics.Dimension) string {
+    parts := make([]string, len(dimensions))
+    for i, dim := range dimensions {
+        parts[i] = dim.Name + "=" + dim.Value
+    }
+
+    return strings.Join(parts, "\x00")
+}
@@ -0,0 +1,77 @@
+package emf
+
+import (
+    "bytes"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/onedaycat/zamus/metrics"
+    "github.com/stretchr/testify/require"
+)
+
+func TestEMF(t *testing.T) {
+    buf := &bytes.Buffer{}
+    e := New("zamus")
+    e.SetWriter(buf)
+    e.SetDimensions(metrics.Dim("FunctionName", "orders"))
+    e.now = func() time.Time { return time.Unix(10, 0) }
+
+    e.Count("Invocations", 1)
+    e.Count("Invocations", 1)
+    e.Timing("Duration", 15*time.Millisecond)
+    e.Timing("Duration", 5*time.Millisecond)
+    e.Count("Errors", 1, metrics.Dim("ErrorCode", "code1"))
+    e.Gauge("BatchSize", 3, metrics.UnitCount, metrics.Dim("FunctionName", "override"))
+    e.Flush()
+
+    lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+    require.Len(t, lines, 3)
+    require.JSONEq(t, `{
+        "_aws": {
+            "Timestamp": 10000,
+            "CloudWatchMetrics": [{
+                "Namespace": "zamus",
+                "Dimensions": [["FunctionName"]],
+                "Metrics": [{"Name": "Invocations", "Unit": "Count"}, {"Name": "Duration", "Unit": "Milliseconds"}]
+            }]
+        },
+        "FunctionName": "orders",
+        "Invocations": 2,
+        "Duration": [15, 5]
+    }`, lines[0])
+    require.JSONEq(t, `{
+        "_aws": {
+            "Timestamp": 10000,
+            "CloudWatchMetrics": [{
+                "Namespace": "zamus",
+                "Dimensions": [["ErrorCode", "FunctionName"]],
+                "Metrics": [{"Name": "Errors", "Unit": "Count"}]
+            }]
+        },
+        "ErrorCode": "code1",
+        "FunctionName": "orders",
+        "Errors": 1
+    }`, lines[1])
+    require.Contains(t, lines[2], `"FunctionName":"override"`)
+
+    t.Run("Flush resets", func(t *testing.T) {
+        buf.Reset()
+        e.Flush()
+
+        require.Empty(t, buf.String())
+    })
+}
+
+func TestEMFMaxMetrics(t *testing.T) {
+    buf := &bytes.Buffer{}
+    e := New("zamus")
+    e.SetWriter(buf)
+
+    for i := 0; i < maxMetrics+1; i++ {
+        e.Count(strings.Repeat("m", i+1), 1)
+    }
+    e.Flush()
+
+    require.Len(t, strings.Split(strings.TrimSpace(buf.String()), "\n"), 2)
+}
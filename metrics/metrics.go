@@ -0,0 +1,34 @@
+package metrics
+
+import (
+    "time"
+)
+
+type Unit string
+
+const (
+    UnitNone         Unit = "None"
+    UnitCount        Unit = "Count"
+    UnitMilliseconds Unit = "Milliseconds"
+    UnitBytes        Unit = "Bytes"
+)
+
+type Dimension struct {
+    Name  string
+    Value string
+}
+
+func Dim(name, value string) Dimension {
+    return Dimension{Name: name, Value: value}
+}
+
+type Metrics interface {
+    // SetDimensions sets the dimensions added to every metric, e.g. the
+    // function name.
+    SetDimensions(dimensions ...Dimension)
+    Count(name string, value float64, dimensions ...Dimension)
+    Gauge(name string, value float64, unit Unit, dimensions ...Dimension)
+    Timing(name string, value time.Duration, dimensions ...Dimension)
+    // Flush emits everything recorded since the last flush.
+    Flush()
+}
@@ -0,0 +1,73 @@
+package metrics
+
+import (
+    "context"
+    "encoding/json"
+    "reflect"
+    "time"
+
+    "github.com/onedaycat/errors"
+    "github.com/onedaycat/zamus/zamus"
+)
+
+const (
+    MetricInvocations = "Invocations"
+    MetricErrors      = "Errors"
+    MetricRetries     = "Retries"
+    MetricPanics      = "Panics"
+    MetricBatchSize   = "BatchSize"
+    MetricDuration    = "Duration"
+)
+
+// Middleware records the standard metrics of every invocation and flushes
+// them once it completes.
+func Middleware(m Metrics) zamus.Middleware {
+    return func(next zamus.InvokeHandler) zamus.InvokeHandler {
+        return func(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+            start := time.Now()
+            result, err := next(ctx, payload)
+
+            m.Timing(MetricDuration, time.Since(start))
+            m.Count(MetricInvocations, 1)
+
+            if err != nil {
+                m.Count(MetricErrors, 1, Dim("ErrorCode", errorCode(err)))
+            }
+
+            if inv := zamus.InvocationFromContext(ctx); inv != nil {
+                if inv.Attempts > 1 {
+                    m.Count(MetricRetries, float64(inv.Attempts-1))
+                }
+
+                if inv.Panicked {
+                    m.Count(MetricPanics, 1)
+                }
+
+                if inv.IsBatch {
+                    m.Gauge(MetricBatchSize, float64(batchSize(inv.Source)), UnitCount)
+                }
+            }
+
+            m.Flush()
+
+            return result, err
+        }
+    }
+}
+
+func errorCode(err error) string {
+    if xerr, ok := err.(errors.Error); ok {
+        return xerr.GetCode()
+    }
+
+    return zamus.GetErrorType(err)
+}
+
+func batchSize(src interface{}) int {
+    v := reflect.ValueOf(src)
+    if v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+        return v.Len()
+    }
+
+    return 0
+}
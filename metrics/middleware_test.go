@@ -0,0 +1,93 @@
+package metrics
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/onedaycat/errors"
+    "github.com/onedaycat/zamus/zamus"
+    "github.com/onedaycat/zamus/zamus/zamustest"
+    "github.com/stretchr/testify/require"
+)
+
+type record struct {
+    name       string
+    value      float64
+    dimensions []Dimension
+}
+
+type testMetrics struct {
+    records []record
+    flushed int
+}
+
+func (m *testMetrics) SetDimensions(dimensions ...Dimension) {}
+
+func (m *testMetrics) Count(name string, value float64, dimensions ...Dimension) {
+    m.records = append(m.records, record{name, value, dimensions})
+}
+
+func (m *testMetrics) Gauge(name string, value float64, unit Unit, dimensions ...Dimension) {
+    m.records = append(m.records, record{name, value, dimensions})
+}
+
+func (m *testMetrics) Timing(name string, value time.Duration, dimensions ...Dimension) {
+    m.records = append(m.records, record{name: name})
+}
+
+func (m *testMetrics) Flush() {
+    m.flushed++
+}
+
+func TestMiddleware(t *testing.T) {
+    th := &zamustest.Handler{}
+    h := zamus.New(th)
+    h.SetRetry(2)
+    m := &testMetrics{}
+    h.Use(Middleware(m))
+    ctx := context.Background()
+
+    t.Run("Retried error", func(t *testing.T) {
+        m.records = nil
+        th.HandlerFunc = func(ctx context.Context, source interface{}) (interface{}, error) {
+            return nil, errors.InternalError("code1", "msg1")
+        }
+
+        _, err := h.Invoke(ctx, []byte(`{"id":"1"}`))
+
+        require.NotNil(t, err)
+        require.Equal(t, []record{
+            {name: MetricDuration},
+            {name: MetricInvocations, value: 1},
+            {name: MetricErrors, value: 1, dimensions: []Dimension{Dim("ErrorCode", "code1")}},
+            {name: MetricRetries, value: 2},
+        }, m.records)
+        require.Equal(t, 1, m.flushed)
+    })
+
+    t.Run("Panic", func(t *testing.T) {
+        m.records = nil
+        th.HandlerFunc = func(ctx context.Context, source interface{}) (interface{}, error) {
+            panic("boom")
+        }
+
+        _, err := h.Invoke(ctx, []byte(`{"id":"1"}`))
+
+        require.NotNil(t, err)
+        require.Equal(t, record{name: MetricPanics, value: 1}, m.records[len(m.records)-1])
+    })
+
+    t.Run("Batch", func(t *testing.T) {
+        m.records = nil
+
+        _, err := h.Invoke(ctx, []byte(`[{"id":"1"},{"id":"2"}]`))
+
+        require.NoError(t, err)
+        require.Equal(t, []record{
+            {name: MetricDuration},
+            {name: MetricInvocations, value: 1},
+            {name: MetricBatchSize, value: 2},
+        }, m.records)
+    })
+}
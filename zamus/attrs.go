@@ -0,0 +1,127 @@
+package zamus
+
+import (
+    "context"
+    "sync"
+    "time"
+)
+
+// Attributes is a bag of values shared by the middlewares, pre-handlers,
+// handler, post-handlers and retry-failed handler of one invocation. It is
+// safe for concurrent use.
+type Attributes struct {
+    mu     sync.RWMutex
+    values map[string]interface{}
+}
+
+func newAttributes() *Attributes {
+    return &Attributes{
+        values: make(map[string]interface{}),
+    }
+}
+
+// Attrs returns the attributes of the invocation. Outside of Invoke or Run
+// it returns an empty bag which is not attached to anything.
+func Attrs(ctx context.Context) *Attributes {
+    if inv := InvocationFromContext(ctx); inv != nil {
+        return inv.attrs
+    }
+
+    return newAttributes()
+}
+
+func (a *Attributes) Set(key string, value interface{}) {
+    a.mu.Lock()
+    a.values[key] = value
+    a.mu.Unlock()
+}
+
+func (a *Attributes) Get(key string) (interface{}, bool) {
+    a.mu.RLock()
+    value, ok := a.values[key]
+    a.mu.RUnlock()
+
+    return value, ok
+}
+
+func (a *Attributes) Delete(key string) {
+    a.mu.Lock()
+    delete(a.values, key)
+    a.mu.Unlock()
+}
+
+// All returns a copy of every attribute.
+func (a *Attributes) All() map[string]interface{} {
+    a.mu.RLock()
+    defer a.mu.RUnlock()
+
+    values := make(map[string]interface{}, len(a.values))
+    for key, value := range a.values {
+        values[key] = value
+    }
+
+    return values
+}
+
+// String returns the attribute when it is a string, or an empty string.
+func (a *Attributes) String(key string) string {
+    value, _ := a.Get(key)
+    s, _ := value.(string)
+
+    return s
+}
+
+// Int returns the attribute when it is a number, or zero.
+func (a *Attributes) Int(key string) int {
+    return int(a.Int64(key))
+}
+
+// Int64 returns the attribute when it is a number, or zero.
+func (a *Attributes) Int64(key string) int64 {
+    value, _ := a.Get(key)
+    switch v := value.(type) {
+    case int:
+        return int64(v)
+    case int32:
+        return int64(v)
+    case int64:
+        return v
+    case float64:
+        return int64(v)
+    }
+
+    return 0
+}
+
+// Float64 returns the attribute when it is a number, or zero.
+func (a *Attributes) Float64(key string) float64 {
+    value, _ := a.Get(key)
+    switch v := value.(type) {
+    case float64:
+        return v
+    case float32:
+        return float64(v)
+    case int:
+        return float64(v)
+    case int64:
+        return float64(v)
+    }
+
+    return 0
+}
+
+// Bool returns the attribute when it is a bool, or false.
+func (a *Attributes) Bool(key string) bool {
+    value, _ := a.Get(key)
+    b, _ := value.(bool)
+
+    return b
+}
+
+// Time returns the attribute when it is a time.Time, or the zero time.
+func (a *Attributes) Time(key string) time.Time {
+    value, _ := a.Get(key)
+    t, _ := value.(time.Time)
+
+    return t
+}
@@ -0,0 +1,218 @@
+package cache
+
+import (
+    "bytes"
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "strings"
+    "time"
+
+    "github.com/onedaycat/errors"
+    "github.com/onedaycat/zamus/zamus"
+)
+
+// Cache is the backend holding encoded results. Get reports a miss with
+// false and a nil error.
+type Cache interface {
+    Get(ctx context.Context, key string) ([]byte, bool, error)
+    Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+type IsCacheable func(err error) bool
+
+type entry struct {
+    Result json.RawMessage   `json:"result,omitempty"`
+    Error  *errors.JSONError `json:"error,omitempty"`
+}
+
+// Memo caches the results of a pure Handle keyed on its payload. Results
+// are returned as their json.RawMessage, whether they come from the cache or
+// from the handler, so the type does not depend on the state of the cache.
+// Payloads which are not cached, see Key, get the result of the handler.
+type Memo struct {
+    cache       Cache
+    prefix      string
+    ttl         time.Duration
+    negativeTTL time.Duration
+    isCacheable IsCacheable
+    fields      []string
+    maxSize     int
+    group       *group
+}
+
+func New(cache Cache) *Memo {
+    return &Memo{
+        cache:       cache,
+        ttl:         time.Minute,
+        isCacheable: defaultIsCacheable,
+        group:       newGroup(),
+    }
+}
+
+// defaultIsCacheable keeps errors which depend on the input only.
+func defaultIsCacheable(err error) bool {
+    xerr, ok := err.(errors.Error)
+    if !ok || xerr.IsPanic() {
+        return false
+    }
+
+    return xerr.GetType() == errors.BadRequestType || xerr.GetType() == errors.NotFoundType
+}
+
+func (m *Memo) SetTTL(ttl time.Duration) {
+    m.ttl = ttl
+}
+
+// SetNegativeTTL caches the errors accepted by SetIsCacheable for ttl. Zero,
+// the default, never caches errors.
+func (m *Memo) SetNegativeTTL(ttl time.Duration) {
+    m.negativeTTL = ttl
+}
+
+// SetIsCacheable decides which errors are cached. By default only BadRequest
+// and NotFound errors are.
+func (m *Memo) SetIsCacheable(isCacheable IsCacheable) {
+    m.isCacheable = isCacheable
+}
+
+// SetKeyFields builds the key from the fields at the given dot paths, such
+// as "arguments.id", instead of the whole payload. Payloads missing one of
+// the fields are not cached.
+func (m *Memo) SetKeyFields(paths ...string) {
+    m.fields = paths
+}
+
+// SetPrefix namespaces the keys of a shared backend.
+func (m *Memo) SetPrefix(prefix string) {
+    m.prefix = prefix
+}
+
+// SetMaxSize skips caching results encoding to more than size bytes. Zero
+// disables the limit.
+func (m *Memo) SetMaxSize(size int) {
+    m.maxSize = size
+}
+
+// Middleware answers from the cache when it can. Concurrent invocations
+// missing the same key wait for a single call to the handler.
+func (m *Memo) Middleware(next zamus.InvokeHandler) zamus.InvokeHandler {
+    return func(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+        key, ok := m.Key(payload)
+        if !ok {
+            return next(ctx, payload)
+        }
+
+        if result, err, hit := m.get(ctx, key); hit {
+            return result, err
+        }
+
+        return m.group.Do(key, func() (interface{}, error) {
+            if result, err, hit := m.get(ctx, key); hit {
+                return result, err
+            }
+
+            result, err := next(ctx, payload)
+
+            return m.set(ctx, key, result, err)
+        })
+    }
+}
+
+// Key returns the cache key of payload. Payloads which are not JSON or miss
+// a key field are not cached.
+func (m *Memo) Key(payload json.RawMessage) (string, bool) {
+    var value interface{}
+    dec := json.NewDecoder(bytes.NewReader(payload))
+    dec.UseNumber()
+    if err := dec.Decode(&value); err != nil {
+        return "", false
+    }
+
+    if len(m.fields) > 0 {
+        selected := make(map[string]interface{}, len(m.fields))
+        for _, path := range m.fields {
+            field := lookup(value, path)
+            if field == nil {
+                return "", false
+            }
+            selected[path] = field
+        }
+        value = selected
+    }
+
+    // encoding/json sorts map keys, so equal documents encode the same.
+    data, err := json.Marshal(value)
+    if err != nil {
+        return "", false
+    }
+
+    sum := sha256.Sum256(data)
+
+    return m.prefix + hex.EncodeToString(sum[:]), true
+}
+
+func lookup(value interface{}, path string) interface{} {
+    for _, key := range strings.Split(path, ".") {
+        m, ok := value.(map[string]interface{})
+        if !ok {
+            return nil
+        }
+        value = m[key]
+    }
+
+    return value
+}
+
+func (m *Memo) get(ctx context.Context, key string) (interface{}, error, bool) {
+    data, ok, err := m.cache.Get(ctx, key)
+    if err != nil || !ok {
+        return nil, nil, false
+    }
+
+    e := &entry{}
+    if err = json.Unmarshal(data, e); err != nil {
+        return nil, nil, false
+    }
+
+    if e.Error != nil {
+        return nil, errors.NewWithTypeAndCode(e.Error.ErrType, e.Error.Code, e.Error.Message), true
+    }
+
+    if len(e.Result) == 0 {
+        return nil, nil, true
+    }
+
+    return e.Result, nil, true
+}
+
+// set returns the result as it is returned on a hit. A result which cannot
+// be encoded is returned as is and not cached.
+func (m *Memo) set(ctx context.Context, key string, result interface{}, err error) (interface{}, error) {
+    e := &entry{}
+    ttl := m.ttl
+    if err != nil {
+        if m.negativeTTL <= 0 || !m.isCacheable(err) {
+            return result, err
+        }
+        ttl = m.negativeTTL
+        e.Error = zamus.NewResultError(err)
+    } else if result != nil {
+        data, merr := json.Marshal(result)
+        if merr != nil {
+            return result, err
+        }
+        e.Result = data
+        result = e.Result
+    }
+
+    data, merr := json.Marshal(e)
+    if merr != nil || (m.maxSize > 0 && len(data) > m.maxSize) {
+        return result, err
+    }
+
+    _ = m.cache.Set(ctx, key, data, ttl)
+
+    return result, err
+}
@@ -0,0 +1,164 @@
+package cache
+
+import (
+    "context"
+    "encoding/json"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/onedaycat/errors"
+    "github.com/onedaycat/zamus/zamus"
+    "github.com/onedaycat/zamus/zamus/zamustest"
+    "github.com/stretchr/testify/require"
+)
+
+type testRes struct {
+    Name string `json:"name"`
+}
+
+func TestMemo(t *testing.T) {
+    var called int32
+    th := &zamustest.Handler{
+        HandlerFunc: func(ctx context.Context, source interface{}) (interface{}, error) {
+            atomic.AddInt32(&called, 1)
+            return &testRes{Name: "a"}, nil
+        },
+    }
+    m := New(NewLRU(10))
+    h := zamus.New(th)
+    h.Use(m.Middleware)
+    ctx := context.Background()
+
+    result, err := h.Invoke(ctx, []byte(`{"id":"1","opts":{"a":1,"b":2}}`))
+    require.NoError(t, err)
+    require.Equal(t, json.RawMessage(`{"name":"a"}`), result)
+
+    result, err = h.Invoke(ctx, []byte(` {"opts":{"b":2,"a":1},"id":"1"}`))
+    require.NoError(t, err)
+    require.Equal(t, json.RawMessage(`{"name":"a"}`), result)
+    require.Equal(t, int32(1), called)
+
+    _, err = h.Invoke(ctx, []byte(`{"id":"2"}`))
+    require.NoError(t, err)
+    require.Equal(t, int32(2), called)
+
+    t.Run("Key fields", func(t *testing.T) {
+        m.SetKeyFields("id")
+
+        k1, ok := m.Key([]byte(`{"id":"1","trace":"a"}`))
+        require.True(t, ok)
+        k2, _ := m.Key([]byte(`{"id":"1","trace":"b"}`))
+        k3, _ := m.Key([]byte(`{"id":"2","trace":"a"}`))
+        require.Equal(t, k1, k2)
+        require.NotEqual(t, k1, k3)
+
+        _, ok = m.Key([]byte(`not json`))
+        require.False(t, ok)
+    })
+
+    t.Run("Missing key field", func(t *testing.T) {
+        atomic.StoreInt32(&called, 0)
+        m.SetKeyFields("arguments.id")
+        defer m.SetKeyFields()
+
+        _, ok := m.Key([]byte(`{"arguments":{"name":"alice"}}`))
+        require.False(t, ok)
+
+        _, err := h.Invoke(ctx, []byte(`{"arguments":{"name":"alice"}}`))
+        require.NoError(t, err)
+        result, err := h.Invoke(ctx, []byte(`{"arguments":{"name":"bob"}}`))
+        require.NoError(t, err)
+        require.Equal(t, &testRes{Name: "a"}, result)
+        require.Equal(t, int32(2), called)
+    })
+
+    t.Run("Max size", func(t *testing.T) {
+        atomic.StoreInt32(&called, 0)
+        m.SetKeyFields()
+        m.SetMaxSize(10)
+
+        _, _ = h.Invoke(ctx, []byte(`{"id":"3"}`))
+        _, _ = h.Invoke(ctx, []byte(`{"id":"3"}`))
+        require.Equal(t, int32(2), called)
+    })
+}
+
+func TestMemoErrors(t *testing.T) {
+    var called int32
+    var handlerErr error
+    m := New(NewLRU(10))
+    h := zamus.New(&zamustest.Handler{
+        HandlerFunc: func(ctx context.Context, source interface{}) (interface{}, error) {
+            atomic.AddInt32(&called, 1)
+            return nil, handlerErr
+        },
+    })
+    h.Use(m.Middleware)
+    ctx := context.Background()
+
+    tests := []struct {
+        name        string
+        negativeTTL time.Duration
+        err         error
+        called      int32
+    }{
+        {"Not cached by default", 0, errors.NotFound("code1", "msg1"), 2},
+        {"NotFound", time.Minute, errors.NotFound("code1", "msg1"), 1},
+        {"InternalError", time.Minute, errors.InternalError("code1", "msg1"), 2},
+        {"Success without result", 0, nil, 1},
+    }
+
+    for i, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            atomic.StoreInt32(&called, 0)
+            m.SetNegativeTTL(tt.negativeTTL)
+            handlerErr = tt.err
+            payload := []byte(`{"id":` + string(rune('0'+i)) + `}`)
+
+            _, err1 := h.Invoke(ctx, payload)
+            result, err2 := h.Invoke(ctx, payload)
+
+            require.Equal(t, tt.called, atomic.LoadInt32(&called))
+            require.Nil(t, result)
+            if tt.err == nil {
+                require.NoError(t, err2)
+                return
+            }
+            require.Equal(t, err1.Error(), err2.Error())
+            require.Equal(t, tt.err.(errors.Error).GetType(), err2.(errors.Error).GetType())
+        })
+    }
+}
+
+func TestMemoStampede(t *testing.T) {
+    var called int32
+    release := make(chan struct{})
+    m := New(NewLRU(10))
+    h := zamus.New(&zamustest.Handler{
+        HandlerFunc: func(ctx context.Context, source interface{}) (interface{}, error) {
+            atomic.AddInt32(&called, 1)
+            <-release
+            return &testRes{Name: "a"}, nil
+        },
+    })
+    h.Use(m.Middleware)
+
+    var wg sync.WaitGroup
+    for i := 0; i < 10; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            result, err := h.Invoke(context.Background(), []byte(`{"id":"1"}`))
+            require.NoError(t, err)
+            require.Equal(t, json.RawMessage(`{"name":"a"}`), result)
+        }()
+    }
+
+    time.Sleep(20 * time.Millisecond)
+    close(release)
+    wg.Wait()
+
+    require.Equal(t, int32(1), atomic.LoadInt32(&called))
+}
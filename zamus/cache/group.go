@@ -0,0 +1,60 @@
+package cache
+
+import (
+    "sync"
+
+    "github.com/onedaycat/zamus/zamus"
+)
+
+type call struct {
+    done   chan struct{}
+    result interface{}
+    err    error
+}
+
+// group runs one call per key at a time and shares its outcome with every
+// caller waiting on the same key.
+type group struct {
+    mu    sync.Mutex
+    calls map[string]*call
+}
+
+func newGroup() *group {
+    return &group{
+        calls: make(map[string]*call),
+    }
+}
+
+func (g *group) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
+    g.mu.Lock()
+    if c, ok := g.calls[key]; ok {
+        g.mu.Unlock()
+        <-c.done
+        return c.result, c.err
+    }
+
+    c := &call{done: make(chan struct{})}
+    g.calls[key] = c
+    g.mu.Unlock()
+
+    // A panic is shared as an error, and raised again for this caller.
+    defer func() {
+        r := recover()
+        if r != nil {
+            c.result, c.err = nil, zamus.PanicError(r)
+        }
+
+        g.mu.Lock()
+        delete(g.calls, key)
+        g.mu.Unlock()
+        close(c.done)
+
+        if r != nil {
+            panic(r)
+        }
+    }()
+
+    c.result, c.err = fn()
+
+    return c.result, c.err
+}
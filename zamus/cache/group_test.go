@@ -0,0 +1,42 @@
+package cache
+
+import (
+    "testing"
+    "time"
+
+    "github.com/onedaycat/errors"
+    "github.com/stretchr/testify/require"
+)
+
+func TestGroupPanic(t *testing.T) {
+    g := newGroup()
+    type outcome struct {
+        result interface{}
+        err    error
+    }
+    waited := make(chan *outcome)
+
+    require.PanicsWithValue(t, "boom", func() {
+        _, _ = g.Do("k", func() (interface{}, error) {
+            go func() {
+                result, err := g.Do("k", func() (interface{}, error) {
+                    return "fresh", nil
+                })
+                waited <- &outcome{result, err}
+            }()
+            time.Sleep(20 * time.Millisecond)
+            panic("boom")
+        })
+    })
+
+    o := <-waited
+    require.Nil(t, o.result)
+    require.Error(t, o.err)
+    require.True(t, o.err.(errors.Error).IsPanic())
+
+    result, err := g.Do("k", func() (interface{}, error) {
+        return "fresh", nil
+    })
+    require.NoError(t, err)
+    require.Equal(t, "fresh", result)
+}
@@ -0,0 +1,91 @@
+package cache
+
+import (
+    "container/list"
+    "context"
+    "sync"
+    "time"
+)
+
+type lruItem struct {
+    key    string
+    value  []byte
+    expiry time.Time
+}
+
+// LRU is an in-process Cache. Declared at package level it is shared by
+// every warm invocation of the execution environment.
+type LRU struct {
+    mu    sync.Mutex
+    size  int
+    items map[string]*list.Element
+    order *list.List
+    now   func() time.Time
+}
+
+// NewLRU keeps at most size entries, evicting the least recently used.
+func NewLRU(size int) *LRU {
+    return &LRU{
+        size:  size,
+        items: make(map[string]*list.Element),
+        order: list.New(),
+        now:   time.Now,
+    }
+}
+
+func (c *LRU) Get(ctx context.Context, key string) ([]byte, bool, error) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    el, ok := c.items[key]
+    if !ok {
+        return nil, false, nil
+    }
+
+    item := el.Value.(*lruItem)
+    if !item.expiry.IsZero() && !c.now().Before(item.expiry) {
+        c.remove(el)
+        return nil, false, nil
+    }
+
+    c.order.MoveToFront(el)
+
+    return item.value, true, nil
+}
+
+func (c *LRU) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    var expiry time.Time
+    if ttl > 0 {
+        expiry = c.now().Add(ttl)
+    }
+
+    if el, ok := c.items[key]; ok {
+        item := el.Value.(*lruItem)
+        item.value = value
+        item.expiry = expiry
+        c.order.MoveToFront(el)
+        return nil
+    }
+
+    c.items[key] = c.order.PushFront(&lruItem{key: key, value: value, expiry: expiry})
+    for c.size > 0 && c.order.Len() > c.size {
+        c.remove(c.order.Back())
+    }
+
+    return nil
+}
+
+func (c *LRU) Len() int {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    return c.order.Len()
+}
+
+func (c *LRU) remove(el *list.Element) {
+    c.order.Remove(el)
+    delete(c.items, el.Value.(*lruItem).key)
+}
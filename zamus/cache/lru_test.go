@@ -0,0 +1,40 @@
+package cache
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/stretchr/testify/require"
+)
+
+func TestLRU(t *testing.T) {
+    now := time.Unix(0, 0)
+    c := NewLRU(2)
+    c.now = func() time.Time { return now }
+    ctx := context.Background()
+
+    require.NoError(t, c.Set(ctx, "a", []byte("1"), time.Minute))
+    require.NoError(t, c.Set(ctx, "b", []byte("2"), 0))
+
+    value, ok, err := c.Get(ctx, "a")
+    require.NoError(t, err)
+    require.True(t, ok)
+    require.Equal(t, []byte("1"), value)
+
+    t.Run("Evict least recently used", func(t *testing.T) {
+        require.NoError(t, c.Set(ctx, "c", []byte("3"), time.Minute))
+
+        _, ok, _ := c.Get(ctx, "b")
+        require.False(t, ok)
+        require.Equal(t, 2, c.Len())
+    })
+
+    t.Run("Expire", func(t *testing.T) {
+        now = now.Add(time.Minute)
+
+        _, ok, _ := c.Get(ctx, "a")
+        require.False(t, ok)
+        require.Equal(t, 1, c.Len())
+    })
+}
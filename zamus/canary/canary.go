@@ -0,0 +1,294 @@
+package canary
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "hash/fnv"
+    "math/rand"
+    "strconv"
+    "strings"
+
+    jsoniter "github.com/json-iterator/go"
+    "github.com/onedaycat/errors"
+    "github.com/onedaycat/zamus/tracer"
+    "github.com/onedaycat/zamus/zamus"
+)
+
+var jsonen = jsoniter.ConfigCompatibleWithStandardLibrary
+
+// Key is the attribute and trace tag holding the name of the variant.
+const Key = "canary"
+
+var ErrNoVariant = errors.DefInternalError("NoVariant", "No canary variant registered")
+
+type variantKey struct{}
+
+// VariantFromContext returns the name of the variant handling the
+// invocation, or an empty string outside of a Canary. Outer middlewares and
+// post handlers see it once the payload is parsed, through the zamus.Attrs
+// of the invocation.
+func VariantFromContext(ctx context.Context) string {
+    if name, ok := ctx.Value(variantKey{}).(string); ok {
+        return name
+    }
+
+    return zamus.Attrs(ctx).String(Key)
+}
+
+// Routed is the source seen by pre and post handlers. The variant handler
+// receives Source only.
+type Routed struct {
+    Variant string
+    Source  interface{}
+}
+
+// Matcher decides from the payload whether a rule applies. A batch payload
+// is matched on its first item.
+type Matcher func(payload map[string]interface{}) bool
+
+type variant struct {
+    name    string
+    handler zamus.Handler
+    weight  int
+}
+
+type rule struct {
+    match   Matcher
+    variant *variant
+}
+
+// Canary is a zamus.Handler splitting traffic between versions of a
+// handler. Rules are checked first, then the hash of the key field when it
+// is present in the payload, then a weighted random pick.
+type Canary struct {
+    variants []*variant
+    rules    []*rule
+    total    int
+    key      string
+    tracer   tracer.Tracer
+    random   func(n int) int
+}
+
+func New() *Canary {
+    return &Canary{
+        random: rand.Intn,
+    }
+}
+
+// Add registers a variant receiving weight parts of the traffic. A zero
+// weight variant is only reached through rules.
+func (c *Canary) Add(name string, handler zamus.Handler, weight int) {
+    c.variants = append(c.variants, &variant{
+        name:    name,
+        handler: handler,
+        weight:  weight,
+    })
+    c.total += weight
+}
+
+// Route sends the payloads matching match to the named variant. It panics
+// when the variant is not registered.
+func (c *Canary) Route(name string, match Matcher) {
+    v := c.variant(name)
+    if v == nil {
+        panic(fmt.Sprintf("canary: variant %s is not registered", name))
+    }
+
+    c.rules = append(c.rules, &rule{match: match, variant: v})
+}
+
+// SetHashKey picks the variant from a hash of the field at path, in dot
+// notation such as "detail.customerId", so the same value always hits the
+// same variant while the weights do not change.
+func (c *Canary) SetHashKey(path string) {
+    c.key = path
+}
+
+// SetTracer tags the trace with the chosen variant under "canary".
+func (c *Canary) SetTracer(t tracer.Tracer) {
+    c.tracer = t
+}
+
+func (c *Canary) ParseSource(ctx context.Context, payload json.RawMessage) interface{} {
+    v := c.choose(decode(payload))
+    zamus.Attrs(ctx).Set(Key, v.name)
+
+    return &Routed{
+        Variant: v.name,
+        Source:  v.handler.ParseSource(ctx, payload),
+    }
+}
+
+func (c *Canary) ParseSources(ctx context.Context, payload json.RawMessage) interface{} {
+    var items []json.RawMessage
+    _ = jsonen.Unmarshal(payload, &items)
+
+    var first map[string]interface{}
+    if len(items) > 0 {
+        first = decode(items[0])
+    }
+    v := c.choose(first)
+    zamus.Attrs(ctx).Set(Key, v.name)
+
+    return &Routed{
+        Variant: v.name,
+        Source:  v.handler.ParseSources(ctx, payload),
+    }
+}
+
+func (c *Canary) Handler(ctx context.Context, source interface{}) (interface{}, error) {
+    routed := source.(*Routed)
+    v := c.variant(routed.Variant)
+
+    return v.handler.Handler(c.withVariant(ctx, v), routed.Source)
+}
+
+func (c *Canary) BatchHandler(ctx context.Context, sources interface{}) (interface{}, error) {
+    routed := sources.(*Routed)
+    v := c.variant(routed.Variant)
+
+    return v.handler.BatchHandler(c.withVariant(ctx, v), routed.Source)
+}
+
+func (c *Canary) withVariant(ctx context.Context, v *variant) context.Context {
+    if c.tracer != nil {
+        c.tracer.SetTag(ctx, Key, v.name)
+    }
+
+    return context.WithValue(ctx, variantKey{}, v.name)
+}
+
+func (c *Canary) variant(name string) *variant {
+    for _, v := range c.variants {
+        if v.name == name {
+            return v
+        }
+    }
+
+    return nil
+}
+
+func (c *Canary) choose(payload map[string]interface{}) *variant {
+    if len(c.variants) == 0 {
+        panic(ErrNoVariant.New())
+    }
+
+    for _, r := range c.rules {
+        if payload != nil && r.match(payload) {
+            return r.variant
+        }
+    }
+
+    if c.total <= 0 {
+        return c.variants[0]
+    }
+
+    n := -1
+    if c.key != "" {
+        if key, ok := lookup(payload, c.key); ok {
+            hash := fnv.New32a()
+            _, _ = hash.Write([]byte(key))
+            n = int(hash.Sum32() % uint32(c.total))
+        }
+    }
+    if n < 0 {
+        n = c.random(c.total)
+    }
+
+    for _, v := range c.variants {
+        if n < v.weight {
+            return v
+        }
+        n -= v.weight
+    }
+
+    return c.variants[len(c.variants)-1]
+}
+
+func decode(payload json.RawMessage) map[string]interface{} {
+    var m map[string]interface{}
+    if err := jsonen.Unmarshal(payload, &m); err != nil {
+        return nil
+    }
+
+    return m
+}
+
+// lookup walks path through nested objects. Keys fall back to a case
+// insensitive match, as HTTP headers arrive in any case.
+func lookup(payload map[string]interface{}, path string) (string, bool) {
+    var value interface{} = payload
+    for _, key := range strings.Split(path, ".") {
+        m, ok := value.(map[string]interface{})
+        if !ok {
+            return "", false
+        }
+
+        value, ok = field(m, key)
+        if !ok {
+            return "", false
+        }
+    }
+
+    switch v := value.(type) {
+    case nil, map[string]interface{}, []interface{}:
+        return "", false
+    case string:
+        return v, true
+    case float64:
+        return strconv.FormatFloat(v, 'f', -1, 64), true
+    default:
+        return fmt.Sprint(v), true
+    }
+}
+
+func field(m map[string]interface{}, key string) (interface{}, bool) {
+    if v, ok := m[key]; ok {
+        return v, true
+    }
+
+    for k, v := range m {
+        if strings.EqualFold(k, key) {
+            return v, true
+        }
+    }
+
+    return nil, false
+}
+
+// Field matches when the field at path equals value.
+func Field(path, value string) Matcher {
+    return func(payload map[string]interface{}) bool {
+        v, ok := lookup(payload, path)
+        return ok && v == value
+    }
+}
+
+// Header matches an API Gateway request header, regardless of case.
+func Header(name, value string) Matcher {
+    return Field("headers."+name, value)
+}
+
+// Attribute matches a string message attribute of the first SQS or SNS
+// record.
+func Attribute(name, value string) Matcher {
+    return func(payload map[string]interface{}) bool {
+        records, ok := payload["Records"].([]interface{})
+        if !ok || len(records) == 0 {
+            return false
+        }
+
+        record, ok := records[0].(map[string]interface{})
+        if !ok {
+            return false
+        }
+
+        if v, ok := lookup(record, "messageAttributes."+name+".stringValue"); ok {
+            return v == value
+        }
+
+        v, ok := lookup(record, "Sns.MessageAttributes."+name+".Value")
+        return ok && v == value
+    }
+}
@@ -0,0 +1,151 @@
+package canary
+
+import (
+    "context"
+    "encoding/json"
+    "testing"
+
+    "github.com/onedaycat/zamus/logger"
+    "github.com/onedaycat/zamus/zamus"
+    "github.com/onedaycat/zamus/zamus/zamustest"
+    "github.com/stretchr/testify/require"
+)
+
+type testReq struct {
+    ID       string `json:"id"`
+    Customer int    `json:"customer"`
+}
+
+func newVersion(version string) *zamustest.Handler {
+    return &zamustest.Handler{
+        NewSource: func() interface{} { return &testReq{} },
+        HandlerFunc: func(ctx context.Context, source interface{}) (interface{}, error) {
+            return version + ":" + VariantFromContext(ctx) + ":" + source.(*testReq).ID, nil
+        },
+        BatchHandlerFunc: func(ctx context.Context, sources interface{}) (interface{}, error) {
+            return version + ":" + VariantFromContext(ctx) + ":" + sources.([]*testReq)[1].ID, nil
+        },
+    }
+}
+
+func TestCanaryWeights(t *testing.T) {
+    c := New()
+    c.Add("stable", newVersion("v1"), 90)
+    c.Add("canary", newVersion("v2"), 10)
+    h := zamus.New(c)
+
+    var picked int
+    c.random = func(n int) int {
+        require.Equal(t, 100, n)
+        return picked
+    }
+
+    tests := []struct {
+        random int
+        result string
+    }{
+        {0, "v1:stable:1"},
+        {89, "v1:stable:1"},
+        {90, "v2:canary:1"},
+        {99, "v2:canary:1"},
+    }
+
+    for _, tt := range tests {
+        picked = tt.random
+        result, err := h.Invoke(context.Background(), []byte(`{"id":"1"}`))
+
+        require.NoError(t, err)
+        require.Equal(t, tt.result, result)
+    }
+
+    t.Run("Batch", func(t *testing.T) {
+        picked = 95
+        result, err := h.Invoke(context.Background(), []byte(`[{"id":"1"},{"id":"2"}]`))
+
+        require.NoError(t, err)
+        require.Equal(t, "v2:canary:2", result)
+    })
+}
+
+func TestCanaryHashKey(t *testing.T) {
+    c := New()
+    c.Add("stable", newVersion("v1"), 50)
+    c.Add("canary", newVersion("v2"), 50)
+    c.SetHashKey("customer")
+    c.random = func(n int) int {
+        panic("random must not be used")
+    }
+    h := zamus.New(c)
+
+    seen := make(map[string]bool)
+    for i := 0; i < 20; i++ {
+        payload := []byte(`{"id":"1","customer":` + string(rune('0'+i%10)) + `}`)
+        first, err := h.Invoke(context.Background(), payload)
+        require.NoError(t, err)
+
+        second, err := h.Invoke(context.Background(), payload)
+        require.NoError(t, err)
+        require.Equal(t, first, second)
+        seen[first.(string)] = true
+    }
+    require.Len(t, seen, 2)
+}
+
+func TestCanaryRules(t *testing.T) {
+    c := New()
+    c.Add("stable", newVersion("v1"), 1)
+    c.Add("beta", newVersion("v2"), 0)
+    c.Route("beta", Header("X-Beta", "yes"))
+    c.Route("beta", Attribute("tier", "beta"))
+    c.Route("beta", Field("detail.tenant", "acme"))
+    c.random = func(n int) int { return 0 }
+
+    tests := []struct {
+        name    string
+        payload string
+        result  string
+    }{
+        {"Header", `{"id":"1","headers":{"x-beta":"yes"}}`, "v2:beta:1"},
+        {"SQS attribute", `{"id":"1","Records":[{"messageAttributes":{"tier":{"stringValue":"beta"}}}]}`, "v2:beta:1"},
+        {"SNS attribute", `{"id":"1","Records":[{"Sns":{"MessageAttributes":{"tier":{"Type":"String","Value":"beta"}}}}]}`, "v2:beta:1"},
+        {"Field", `{"id":"1","detail":{"tenant":"acme"}}`, "v2:beta:1"},
+        {"No match", `{"id":"1","headers":{"x-beta":"no"}}`, "v1:stable:1"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            result, err := c.Handler(context.Background(), c.ParseSource(context.Background(), []byte(tt.payload)))
+
+            require.NoError(t, err)
+            require.Equal(t, tt.result, result)
+        })
+    }
+
+    require.Panics(t, func() {
+        c.Route("missing", Header("a", "b"))
+    })
+}
+
+func TestCanaryRequestLogger(t *testing.T) {
+    c := New()
+    c.Add("stable", newVersion("v1"), 0)
+    c.Add("canary", newVersion("v2"), 1)
+    log := &zamustest.Logger{}
+    rl := logger.NewRequestLogger(log)
+    rl.SetTags(Key)
+    h := zamus.New(c)
+    h.Use(rl.Middleware)
+
+    var posted string
+    h.RegisterPostHandler(func(ctx context.Context, payload json.RawMessage, src interface{}, result interface{}, err error) (interface{}, error) {
+        posted = VariantFromContext(ctx)
+        return result, err
+    })
+
+    result, err := h.Invoke(context.Background(), []byte(`{"id":"1"}`))
+
+    require.NoError(t, err)
+    require.Equal(t, "v2:canary:1", result)
+    require.Equal(t, "canary", posted)
+    require.Equal(t, map[string]interface{}{Key: "canary"}, log.Lines()[0].Data[0].(*logger.Entry).Tags)
+}
@@ -0,0 +1,314 @@
+package circuitbreaker
+
+import (
+    "context"
+    "encoding/json"
+    "sync"
+    "time"
+
+    "github.com/onedaycat/errors"
+    "github.com/onedaycat/zamus/zamus"
+)
+
+type State int
+
+const (
+    Closed State = iota
+    Open
+    HalfOpen
+)
+
+func (s State) String() string {
+    switch s {
+    case Closed:
+        return "closed"
+    case Open:
+        return "open"
+    case HalfOpen:
+        return "half-open"
+    }
+
+    return "unknown"
+}
+
+var ErrCircuitOpen = errors.DefInternalError("CircuitOpen", "Circuit breaker is open")
+
+type IsFailure func(err error) bool
+
+// Snapshot is the state shared between warm invocations and, when a Store
+// is set, between execution environments.
+type Snapshot struct {
+    State                State     `json:"state"`
+    Requests             int       `json:"requests"`
+    Failures             int       `json:"failures"`
+    ConsecutiveFailures  int       `json:"consecutiveFailures"`
+    ConsecutiveSuccesses int       `json:"consecutiveSuccesses"`
+    Probes               int       `json:"probes"`
+    Expiry               time.Time `json:"expiry"`
+}
+
+type CircuitBreaker struct {
+    name                string
+    mu                  sync.Mutex
+    snapshot            *Snapshot
+    consecutiveFailures int
+    failureRate         float64
+    minRequests         int
+    window              time.Duration
+    openTimeout         time.Duration
+    halfOpenRequests    int
+    isFailure           IsFailure
+    store               Store
+    now                 func() time.Time
+}
+
+func New(name string) *CircuitBreaker {
+    return &CircuitBreaker{
+        name:                name,
+        snapshot:            &Snapshot{State: Closed},
+        consecutiveFailures: 5,
+        minRequests:         10,
+        window:              time.Minute,
+        openTimeout:         30 * time.Second,
+        halfOpenRequests:    1,
+        isFailure:           defaultIsFailure,
+        now:                 time.Now,
+    }
+}
+
+func defaultIsFailure(err error) bool {
+    return err != nil
+}
+
+// SetConsecutiveFailures trips the circuit after n failures in a row. Zero
+// disables the check.
+func (cb *CircuitBreaker) SetConsecutiveFailures(n int) {
+    cb.consecutiveFailures = n
+}
+
+// SetFailureRate trips the circuit when the ratio of failures within the
+// window reaches rate, once at least minRequests have been recorded. Zero
+// rate disables the check.
+func (cb *CircuitBreaker) SetFailureRate(rate float64, minRequests int) {
+    cb.failureRate = rate
+    cb.minRequests = minRequests
+}
+
+// SetWindow sets how long counts are kept while the circuit is closed.
+func (cb *CircuitBreaker) SetWindow(window time.Duration) {
+    cb.window = window
+}
+
+// SetOpenTimeout sets how long the circuit stays open before letting probe
+// requests through, and how long probes may take before they are forgotten
+// and new ones let through.
+func (cb *CircuitBreaker) SetOpenTimeout(timeout time.Duration) {
+    cb.openTimeout = timeout
+}
+
+// SetHalfOpenRequests sets how many probes may run while half-open, and how
+// many successes in a row close the circuit again.
+func (cb *CircuitBreaker) SetHalfOpenRequests(n int) {
+    cb.halfOpenRequests = n
+}
+
+func (cb *CircuitBreaker) SetIsFailure(isFailure IsFailure) {
+    cb.isFailure = isFailure
+}
+
+// SetStore persists the state so every execution environment sharing the
+// store trips together. Each Allow and Record loads, updates and saves the
+// snapshot without any lock across environments, so concurrent updates may
+// overwrite each other: counts are approximate and a few more probes than
+// SetHalfOpenRequests may run at once.
+func (cb *CircuitBreaker) SetStore(store Store) {
+    cb.store = store
+}
+
+func (cb *CircuitBreaker) Name() string {
+    return cb.name
+}
+
+func (cb *CircuitBreaker) State() State {
+    cb.mu.Lock()
+    defer cb.mu.Unlock()
+
+    cb.expire(cb.now())
+
+    return cb.snapshot.State
+}
+
+func (cb *CircuitBreaker) Middleware(next zamus.InvokeHandler) zamus.InvokeHandler {
+    return func(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+        if err := cb.Allow(ctx); err != nil {
+            return nil, err
+        }
+
+        return cb.call(ctx, payload, next)
+    }
+}
+
+// call records the result even when next panics, as a failure, so a probe
+// never keeps the circuit half-open.
+func (cb *CircuitBreaker) call(ctx context.Context, payload json.RawMessage, next zamus.InvokeHandler) (result interface{}, err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            cb.Record(ctx, zamus.PanicError(r))
+            panic(r)
+        }
+
+        cb.Record(ctx, err)
+    }()
+
+    return next(ctx, payload)
+}
+
+// Allow returns ErrCircuitOpen when the call must fail fast. Every allowed
+// call has to be followed by Record. Probes which never record, because the
+// function timed out, are forgotten after the open timeout.
+func (cb *CircuitBreaker) Allow(ctx context.Context) error {
+    cb.mu.Lock()
+    defer cb.mu.Unlock()
+
+    cb.load(ctx)
+    now := cb.now()
+    cb.expire(now)
+
+    switch cb.snapshot.State {
+    case Open:
+        return ErrCircuitOpen.Newf("Circuit breaker %s is open until %s", cb.name, cb.snapshot.Expiry.Format(time.RFC3339))
+    case HalfOpen:
+        if cb.snapshot.Probes >= cb.halfOpenRequests {
+            return ErrCircuitOpen.Newf("Circuit breaker %s is half-open and waiting for probes", cb.name)
+        }
+        cb.snapshot.Probes++
+        cb.save(ctx)
+    }
+
+    return nil
+}
+
+func (cb *CircuitBreaker) Record(ctx context.Context, err error) {
+    cb.mu.Lock()
+    defer cb.mu.Unlock()
+
+    if xerr, ok := err.(errors.Error); ok && ErrCircuitOpen.Is(xerr) {
+        return
+    }
+
+    cb.load(ctx)
+    now := cb.now()
+    cb.expire(now)
+
+    if cb.isFailure(err) {
+        cb.onFailure(now)
+    } else {
+        cb.onSuccess(now)
+    }
+
+    cb.save(ctx)
+}
+
+func (cb *CircuitBreaker) onSuccess(now time.Time) {
+    s := cb.snapshot
+    switch s.State {
+    case Closed:
+        s.Requests++
+        s.ConsecutiveFailures = 0
+        s.ConsecutiveSuccesses++
+    case HalfOpen:
+        if s.Probes > 0 {
+            s.Probes--
+        }
+        s.ConsecutiveSuccesses++
+        if s.ConsecutiveSuccesses >= cb.halfOpenRequests {
+            cb.setState(Closed, now)
+        }
+    }
+}
+
+func (cb *CircuitBreaker) onFailure(now time.Time) {
+    s := cb.snapshot
+    switch s.State {
+    case Closed:
+        s.Requests++
+        s.Failures++
+        s.ConsecutiveFailures++
+        s.ConsecutiveSuccesses = 0
+        if cb.shouldTrip() {
+            cb.setState(Open, now)
+        }
+    case HalfOpen:
+        cb.setState(Open, now)
+    }
+}
+
+func (cb *CircuitBreaker) shouldTrip() bool {
+    s := cb.snapshot
+    if cb.consecutiveFailures > 0 && s.ConsecutiveFailures >= cb.consecutiveFailures {
+        return true
+    }
+
+    if cb.failureRate > 0 && s.Requests >= cb.minRequests {
+        return float64(s.Failures)/float64(s.Requests) >= cb.failureRate
+    }
+
+    return false
+}
+
+func (cb *CircuitBreaker) expire(now time.Time) {
+    s := cb.snapshot
+    if s.Expiry.IsZero() {
+        if s.State == Closed && cb.window > 0 {
+            s.Expiry = now.Add(cb.window)
+        }
+        return
+    }
+
+    if now.Before(s.Expiry) {
+        return
+    }
+
+    switch s.State {
+    case Closed:
+        cb.setState(Closed, now)
+    case Open, HalfOpen:
+        cb.setState(HalfOpen, now)
+    }
+}
+
+func (cb *CircuitBreaker) setState(state State, now time.Time) {
+    s := &Snapshot{State: state}
+    switch state {
+    case Closed:
+        if cb.window > 0 {
+            s.Expiry = now.Add(cb.window)
+        }
+    case Open, HalfOpen:
+        s.Expiry = now.Add(cb.openTimeout)
+    }
+
+    cb.snapshot = s
+}
+
+func (cb *CircuitBreaker) load(ctx context.Context) {
+    if cb.store == nil {
+        return
+    }
+
+    snapshot, err := cb.store.Load(ctx, cb.name)
+    if err != nil || snapshot == nil {
+        return
+    }
+
+    cb.snapshot = snapshot
+}
+
+func (cb *CircuitBreaker) save(ctx context.Context) {
+    if cb.store == nil {
+        return
+    }
+
+    snapshot := *cb.snapshot
+    _ = cb.store.Save(ctx, cb.name, &snapshot)
+}
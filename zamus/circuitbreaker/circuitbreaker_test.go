@@ -0,0 +1,187 @@
+package circuitbreaker
+
+import (
+    "context"
+    "encoding/json"
+    "testing"
+    "time"
+
+    "github.com/onedaycat/errors"
+    "github.com/onedaycat/zamus/zamus"
+    "github.com/onedaycat/zamus/zamus/zamustest"
+    "github.com/stretchr/testify/require"
+)
+
+type testClock struct {
+    now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+    return c.now
+}
+
+func newTestBreaker(clock *testClock) *CircuitBreaker {
+    cb := New("downstream")
+    cb.now = clock.Now
+    cb.SetConsecutiveFailures(2)
+    cb.SetOpenTimeout(10 * time.Second)
+
+    return cb
+}
+
+func TestCircuitBreakerConsecutiveFailures(t *testing.T) {
+    clock := &testClock{now: time.Now()}
+    cb := newTestBreaker(clock)
+    var handlerErr error = errors.InternalError("code1", "msg1")
+    th := &zamustest.Handler{
+        HandlerFunc: func(ctx context.Context, source interface{}) (interface{}, error) {
+            return nil, handlerErr
+        },
+    }
+    h := zamus.New(th)
+    h.SetRetry(2)
+    h.Use(cb.Middleware)
+
+    ctx := context.Background()
+    payload := []byte(`{"id":"1"}`)
+
+    _, err := h.Invoke(ctx, payload)
+    require.Equal(t, "code1: msg1", err.Error())
+    require.Equal(t, Closed, cb.State())
+
+    _, err = h.Invoke(ctx, payload)
+    require.Equal(t, "code1: msg1", err.Error())
+    require.Equal(t, Open, cb.State())
+    require.Equal(t, 6, th.Calls())
+
+    t.Run("Fail fast while open", func(t *testing.T) {
+        _, err := h.Invoke(ctx, payload)
+        require.True(t, ErrCircuitOpen.Is(err.(errors.Error)))
+        require.Equal(t, 6, th.Calls())
+    })
+
+    t.Run("Half-open probe fails", func(t *testing.T) {
+        clock.now = clock.now.Add(11 * time.Second)
+        require.Equal(t, HalfOpen, cb.State())
+
+        _, err := h.Invoke(ctx, payload)
+        require.Equal(t, "code1: msg1", err.Error())
+        require.Equal(t, Open, cb.State())
+    })
+
+    t.Run("Half-open probe succeeds", func(t *testing.T) {
+        clock.now = clock.now.Add(11 * time.Second)
+        handlerErr = nil
+
+        _, err := h.Invoke(ctx, payload)
+        require.NoError(t, err)
+        require.Equal(t, Closed, cb.State())
+    })
+}
+
+func TestCircuitBreakerFailureRate(t *testing.T) {
+    clock := &testClock{now: time.Now()}
+    cb := newTestBreaker(clock)
+    cb.SetConsecutiveFailures(0)
+    cb.SetFailureRate(0.5, 4)
+    cb.SetWindow(time.Minute)
+    ctx := context.Background()
+    failure := errors.InternalError("code1", "msg1")
+
+    record := func(errs ...error) {
+        for _, err := range errs {
+            require.NoError(t, cb.Allow(ctx))
+            cb.Record(ctx, err)
+        }
+    }
+
+    record(nil, failure, nil)
+    require.Equal(t, Closed, cb.State())
+
+    t.Run("Window resets counts", func(t *testing.T) {
+        clock.now = clock.now.Add(2 * time.Minute)
+        record(failure)
+        require.Equal(t, Closed, cb.State())
+    })
+
+    t.Run("Trip on rate", func(t *testing.T) {
+        record(nil, nil, failure)
+        require.Equal(t, Open, cb.State())
+    })
+}
+
+func TestCircuitBreakerHalfOpenRequests(t *testing.T) {
+    clock := &testClock{now: time.Now()}
+    cb := newTestBreaker(clock)
+    cb.SetHalfOpenRequests(1)
+    ctx := context.Background()
+
+    cb.Record(ctx, errors.New("msg1"))
+    cb.Record(ctx, errors.New("msg1"))
+    clock.now = clock.now.Add(11 * time.Second)
+
+    require.NoError(t, cb.Allow(ctx))
+    err := cb.Allow(ctx)
+    require.True(t, ErrCircuitOpen.Is(err.(errors.Error)))
+}
+
+func TestCircuitBreakerStore(t *testing.T) {
+    clock := &testClock{now: time.Now()}
+    store := NewMemoryStore()
+    cb1 := newTestBreaker(clock)
+    cb1.SetStore(store)
+    cb2 := newTestBreaker(clock)
+    cb2.SetStore(store)
+    ctx := context.Background()
+
+    require.NoError(t, cb1.Allow(ctx))
+    cb1.Record(ctx, errors.New("msg1"))
+    require.NoError(t, cb2.Allow(ctx))
+    cb2.Record(ctx, errors.New("msg1"))
+
+    err := cb1.Allow(ctx)
+    require.True(t, ErrCircuitOpen.Is(err.(errors.Error)))
+}
+
+func TestCircuitBreakerStuckProbe(t *testing.T) {
+    clock := &testClock{now: time.Now()}
+    cb := newTestBreaker(clock)
+    ctx := context.Background()
+
+    cb.Record(ctx, errors.New("msg1"))
+    cb.Record(ctx, errors.New("msg1"))
+    clock.now = clock.now.Add(11 * time.Second)
+
+    require.NoError(t, cb.Allow(ctx))
+
+    t.Run("Wait for the probe", func(t *testing.T) {
+        clock.now = clock.now.Add(5 * time.Second)
+        err := cb.Allow(ctx)
+        require.True(t, ErrCircuitOpen.Is(err.(errors.Error)))
+    })
+
+    t.Run("Forget the probe", func(t *testing.T) {
+        clock.now = clock.now.Add(6 * time.Second)
+        require.Equal(t, HalfOpen, cb.State())
+        require.NoError(t, cb.Allow(ctx))
+        cb.Record(ctx, nil)
+        require.Equal(t, Closed, cb.State())
+    })
+}
+
+func TestCircuitBreakerPanic(t *testing.T) {
+    clock := &testClock{now: time.Now()}
+    cb := newTestBreaker(clock)
+    cb.SetConsecutiveFailures(1)
+    h := zamus.New(&zamustest.Handler{})
+    h.Use(cb.Middleware, func(next zamus.InvokeHandler) zamus.InvokeHandler {
+        return func(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+            panic("boom")
+        }
+    })
+
+    require.PanicsWithValue(t, "boom", func() {
+        _, _ = h.Invoke(context.Background(), []byte(`{"id":"1"}`))
+    })
+    require.Equal(t, Open, cb.State())
+}
@@ -0,0 +1,47 @@
+package circuitbreaker
+
+import (
+    "context"
+    "sync"
+)
+
+// Store shares snapshots between execution environments. Load and Save are
+// separate calls, so an implementation only needs last write wins.
+type Store interface {
+    Load(ctx context.Context, name string) (*Snapshot, error)
+    Save(ctx context.Context, name string, snapshot *Snapshot) error
+}
+
+type memoryStore struct {
+    mu        sync.Mutex
+    snapshots map[string]Snapshot
+}
+
+// NewMemoryStore shares state between breakers of the same process, mostly
+// useful in tests.
+func NewMemoryStore() Store {
+    return &memoryStore{
+        snapshots: make(map[string]Snapshot),
+    }
+}
+
+func (s *memoryStore) Load(ctx context.Context, name string) (*Snapshot, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    snapshot, ok := s.snapshots[name]
+    if !ok {
+        return nil, nil
+    }
+
+    return &snapshot, nil
+}
+
+func (s *memoryStore) Save(ctx context.Context, name string, snapshot *Snapshot) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.snapshots[name] = *snapshot
+
+    return nil
+}
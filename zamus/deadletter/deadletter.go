@@ -0,0 +1,96 @@
+package deadletter
+
+import (
+    "context"
+    "encoding/json"
+    "time"
+
+    "github.com/aws/aws-lambda-go/lambdacontext"
+    "github.com/onedaycat/errors"
+    "github.com/onedaycat/zamus/zamus"
+)
+
+var ErrWriteFailed = errors.DefInternalError("DeadLetterWriteFailed", "Unable to write dead letter")
+
+// Record is what a Sink persists for an invocation that exhausted its
+// retries.
+type Record struct {
+    RequestID  string            `json:"requestId,omitempty"`
+    Payload    json.RawMessage   `json:"payload"`
+    Source     interface{}       `json:"source,omitempty"`
+    ErrType    string            `json:"errType"`
+    ErrCode    string            `json:"errCode"`
+    Message    string            `json:"message"`
+    Stacktrace errors.Stacktrace `json:"stacktrace,omitempty"`
+    Attempts   int               `json:"attempts"`
+    Time       time.Time         `json:"time"`
+}
+
+type Sink interface {
+    Write(ctx context.Context, record *Record) error
+}
+
+type DeadLetter struct {
+    sink    Sink
+    rethrow bool
+    now     func() time.Time
+}
+
+// New returns a dead letter writing to sink and swallowing the error, so the
+// event is not redelivered.
+func New(sink Sink) *DeadLetter {
+    return &DeadLetter{
+        sink: sink,
+        now:  time.Now,
+    }
+}
+
+// SetRethrow returns the original error after writing the record.
+func (d *DeadLetter) SetRethrow(rethrow bool) {
+    d.rethrow = rethrow
+}
+
+// RetryFailedHandler is meant for Handle.OnRetryFailedHandler. When the sink
+// fails the error is returned whatever the configuration, so the event is not
+// lost.
+func (d *DeadLetter) RetryFailedHandler(ctx context.Context, payload json.RawMessage, src interface{}, err error) (interface{}, error) {
+    record := d.NewRecord(ctx, payload, src, err)
+    if werr := d.sink.Write(ctx, record); werr != nil {
+        return nil, ErrWriteFailed.Newf("Unable to write dead letter for %s: %s", record.ErrCode, werr.Error()).WithCause(werr)
+    }
+
+    if d.rethrow {
+        return nil, err
+    }
+
+    return nil, nil
+}
+
+func (d *DeadLetter) NewRecord(ctx context.Context, payload json.RawMessage, src interface{}, err error) *Record {
+    record := &Record{
+        Payload: payload,
+        Source:  src,
+        Time:    d.now().UTC(),
+    }
+
+    if lc, ok := lambdacontext.FromContext(ctx); ok {
+        record.RequestID = lc.AwsRequestID
+    }
+
+    if inv := zamus.InvocationFromContext(ctx); inv != nil {
+        record.Attempts = inv.Attempts
+    }
+
+    if xerr, ok := err.(errors.Error); ok {
+        record.ErrType = xerr.GetType()
+        record.ErrCode = xerr.GetCode()
+        record.Message = xerr.GetMessage()
+        record.Stacktrace = xerr.GetStacktrace()
+    } else if err != nil {
+        record.ErrType = zamus.GetErrorType(err)
+        record.ErrCode = zamus.GetErrorType(err)
+        record.Message = err.Error()
+    }
+
+    return record
+}
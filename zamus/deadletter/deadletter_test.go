@@ -0,0 +1,149 @@
+package deadletter
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "github.com/aws/aws-sdk-go/aws"
+    "github.com/aws/aws-sdk-go/aws/request"
+    "github.com/aws/aws-sdk-go/service/sns"
+    "github.com/aws/aws-sdk-go/service/sns/snsiface"
+    "github.com/aws/aws-sdk-go/service/sqs"
+    "github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+    "github.com/onedaycat/errors"
+    "github.com/onedaycat/zamus/zamus"
+    "github.com/onedaycat/zamus/zamus/zamustest"
+    "github.com/stretchr/testify/require"
+)
+
+type testHandler struct{}
+
+type testSink struct {
+    records []*Record
+    err     error
+}
+
+func (s *testSink) Write(ctx context.Context, record *Record) error {
+    s.records = append(s.records, record)
+    return s.err
+}
+
+type testSQS struct {
+    sqsiface.SQSAPI
+    input *sqs.SendMessageInput
+}
+
+func (c *testSQS) SendMessageWithContext(ctx aws.Context, input *sqs.SendMessageInput, opts ...request.Option) (*sqs.SendMessageOutput, error) {
+    c.input = input
+    return &sqs.SendMessageOutput{}, nil
+}
+
+type testSNS struct {
+    snsiface.SNSAPI
+    input *sns.PublishInput
+}
+
+func (c *testSNS) PublishWithContext(ctx aws.Context, input *sns.PublishInput, opts ...request.Option) (*sns.PublishOutput, error) {
+    c.input = input
+    return &sns.PublishOutput{}, nil
+}
+
+func TestDeadLetter(t *testing.T) {
+    sink := &testSink{}
+    dl := New(sink)
+    h := zamus.New(&zamustest.Handler{
+        NewSource: func() interface{} { return &map[string]interface{}{} },
+        HandlerFunc: func(ctx context.Context, source interface{}) (interface{}, error) {
+            return nil, errors.InternalError("code1", "msg1")
+        },
+    })
+    h.SetRetry(2)
+    h.OnRetryFailedHandler(dl.RetryFailedHandler)
+    ctx := context.Background()
+
+    t.Run("Swallow", func(t *testing.T) {
+        result, err := h.Invoke(ctx, []byte(`{"id":"1"}`))
+
+        require.NoError(t, err)
+        require.Nil(t, result)
+        require.Len(t, sink.records, 1)
+
+        record := sink.records[0]
+        require.Equal(t, json.RawMessage(`{"id":"1"}`), record.Payload)
+        require.Equal(t, &map[string]interface{}{"id": "1"}, record.Source)
+        require.Equal(t, errors.InternalErrorType, record.ErrType)
+        require.Equal(t, "code1", record.ErrCode)
+        require.Equal(t, "msg1", record.Message)
+        require.NotEmpty(t, record.Stacktrace)
+        require.Equal(t, 3, record.Attempts)
+    })
+
+    t.Run("Rethrow", func(t *testing.T) {
+        dl.SetRethrow(true)
+        defer dl.SetRethrow(false)
+
+        _, err := h.Invoke(ctx, []byte(`{"id":"1"}`))
+
+        require.Equal(t, "code1: msg1", err.Error())
+        require.Len(t, sink.records, 2)
+    })
+
+    t.Run("Sink failed", func(t *testing.T) {
+        sink.err = errors.New("unavailable")
+        defer func() { sink.err = nil }()
+
+        _, err := h.Invoke(ctx, []byte(`{"id":"1"}`))
+
+        require.True(t, ErrWriteFailed.Is(err.(errors.Error)))
+    })
+}
+
+func TestFileSink(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "deadletter.jsonl")
+    sink := NewFileSink(path)
+    ctx := context.Background()
+
+    require.NoError(t, sink.Write(ctx, &Record{ErrCode: "code1", Attempts: 1, Source: map[string]interface{}{"id": "1"}}))
+    require.NoError(t, sink.Write(ctx, &Record{ErrCode: "code2", Attempts: 2}))
+
+    f, err := os.Open(path)
+    require.NoError(t, err)
+    defer f.Close()
+
+    var codes []string
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+        record := &Record{}
+        require.NoError(t, json.Unmarshal(scanner.Bytes(), record))
+        codes = append(codes, record.ErrCode)
+    }
+    require.Equal(t, []string{"code1", "code2"}, codes)
+}
+
+func TestSQSSink(t *testing.T) {
+    client := &testSQS{}
+    sink := NewSQSSink(client, "https://sqs/dlq")
+
+    err := sink.Write(context.Background(), &Record{ErrType: "InternalError", ErrCode: "code1", Time: time.Unix(0, 0).UTC()})
+
+    require.NoError(t, err)
+    require.Equal(t, "https://sqs/dlq", *client.input.QueueUrl)
+    require.Equal(t, "code1", *client.input.MessageAttributes["errCode"].StringValue)
+    require.JSONEq(t, `{"payload":null,"errType":"InternalError","errCode":"code1","message":"","attempts":0,"time":"1970-01-01T00:00:00Z"}`, *client.input.MessageBody)
+}
+
+func TestSNSSink(t *testing.T) {
+    client := &testSNS{}
+    sink := NewSNSSink(client, "arn:aws:sns:dlq")
+
+    err := sink.Write(context.Background(), &Record{ErrCode: "code1"})
+
+    require.NoError(t, err)
+    require.Equal(t, "arn:aws:sns:dlq", *client.input.TopicArn)
+    require.Equal(t, "None", *client.input.MessageAttributes["errType"].StringValue)
+}
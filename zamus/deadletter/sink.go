@@ -0,0 +1,121 @@
+package deadletter
+
+import (
+    "context"
+    "encoding/json"
+    "os"
+    "sync"
+
+    "github.com/aws/aws-sdk-go/aws"
+    "github.com/aws/aws-sdk-go/service/sns"
+    "github.com/aws/aws-sdk-go/service/sns/snsiface"
+    "github.com/aws/aws-sdk-go/service/sqs"
+    "github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+type fileSink struct {
+    mu   sync.Mutex
+    path string
+}
+
+// NewFileSink appends one JSON record per line to path, e.g. under /tmp.
+func NewFileSink(path string) Sink {
+    return &fileSink{
+        path: path,
+    }
+}
+
+func (s *fileSink) Write(ctx context.Context, record *Record) error {
+    data, err := json.Marshal(record)
+    if err != nil {
+        return err
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+    if err != nil {
+        return err
+    }
+
+    if _, err = f.Write(append(data, '\n')); err != nil {
+        _ = f.Close()
+        return err
+    }
+
+    return f.Close()
+}
+
+type sqsSink struct {
+    client   sqsiface.SQSAPI
+    queueURL string
+}
+
+// NewSQSSink sends each record as a message to queueURL. The error code and
+// type are also set as message attributes for filtering.
+func NewSQSSink(client sqsiface.SQSAPI, queueURL string) Sink {
+    return &sqsSink{
+        client:   client,
+        queueURL: queueURL,
+    }
+}
+
+func (s *sqsSink) Write(ctx context.Context, record *Record) error {
+    data, err := json.Marshal(record)
+    if err != nil {
+        return err
+    }
+
+    _, err = s.client.SendMessageWithContext(ctx, &sqs.SendMessageInput{
+        QueueUrl:    aws.String(s.queueURL),
+        MessageBody: aws.String(string(data)),
+        MessageAttributes: map[string]*sqs.MessageAttributeValue{
+            "errCode": {DataType: aws.String("String"), StringValue: aws.String(attribute(record.ErrCode))},
+            "errType": {DataType: aws.String("String"), StringValue: aws.String(attribute(record.ErrType))},
+        },
+    })
+
+    return err
+}
+
+type snsSink struct {
+    client   snsiface.SNSAPI
+    topicArn string
+}
+
+// NewSNSSink publishes each record to topicArn. The error code and type are
+// also set as message attributes for subscription filter policies.
+func NewSNSSink(client snsiface.SNSAPI, topicArn string) Sink {
+    return &snsSink{
+        client:   client,
+        topicArn: topicArn,
+    }
+}
+
+func (s *snsSink) Write(ctx context.Context, record *Record) error {
+    data, err := json.Marshal(record)
+    if err != nil {
+        return err
+    }
+
+    _, err = s.client.PublishWithContext(ctx, &sns.PublishInput{
+        TopicArn: aws.String(s.topicArn),
+        Message:  aws.String(string(data)),
+        MessageAttributes: map[string]*sns.MessageAttributeValue{
+            "errCode": {DataType: aws.String("String"), StringValue: aws.String(attribute(record.ErrCode))},
+            "errType": {DataType: aws.String("String"), StringValue: aws.String(attribute(record.ErrType))},
+        },
+    })
+
+    return err
+}
+
+// attribute avoids empty attribute values, which SQS and SNS reject.
+func attribute(value string) string {
+    if value == "" {
+        return "None"
+    }
+
+    return value
+}
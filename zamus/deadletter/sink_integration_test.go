@@ -0,0 +1,46 @@
+// +build integration
+
+package deadletter
+
+import (
+    "context"
+    "os"
+    "testing"
+
+    "github.com/aws/aws-sdk-go/aws"
+    "github.com/aws/aws-sdk-go/aws/credentials"
+    "github.com/aws/aws-sdk-go/aws/session"
+    "github.com/aws/aws-sdk-go/service/sqs"
+    "github.com/stretchr/testify/require"
+)
+
+// Runs against a local SQS stand-in such as ElasticMQ or LocalStack, e.g.
+// SQS_ENDPOINT=http://localhost:9324.
+func TestSQSSinkIntegration(t *testing.T) {
+    endpoint := os.Getenv("SQS_ENDPOINT")
+    if endpoint == "" {
+        t.Skip("SQS_ENDPOINT is not set")
+    }
+
+    sess := session.Must(session.NewSession(&aws.Config{
+        Endpoint:    aws.String(endpoint),
+        Region:      aws.String("us-east-1"),
+        Credentials: credentials.NewStaticCredentials("x", "x", ""),
+    }))
+    client := sqs.New(sess)
+    ctx := context.Background()
+
+    queue, err := client.CreateQueueWithContext(ctx, &sqs.CreateQueueInput{QueueName: aws.String("zamus-deadletter")})
+    require.NoError(t, err)
+
+    sink := NewSQSSink(client, *queue.QueueUrl)
+    require.NoError(t, sink.Write(ctx, &Record{ErrCode: "code1", Attempts: 1}))
+
+    out, err := client.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+        QueueUrl:              queue.QueueUrl,
+        MessageAttributeNames: aws.StringSlice([]string{"All"}),
+    })
+    require.NoError(t, err)
+    require.Len(t, out.Messages, 1)
+    require.Equal(t, "code1", *out.Messages[0].MessageAttributes["errCode"].StringValue)
+}
@@ -1,9 +1,34 @@
 package zamus
 
 import (
+    "fmt"
     "reflect"
+
+    "github.com/onedaycat/errors"
+)
+
+var (
+    ErrAttemptTimeout     = errors.DefTimeout("AttemptTimeout", "Handler attempt timed out")
+    ErrChunkResult        = errors.DefInternalError("ChunkResult", "Batch chunk result must be a slice")
+    ErrDeadlineNear       = errors.DefTimeout("DeadlineNear", "Not started, the invocation is close to its deadline")
+    ErrEmptyPayload       = errors.DefBadRequest("EmptyPayload", "Payload is empty or null")
+    ErrUnsupportedPayload = errors.DefBadRequest("UnsupportedPayload", "Scalar payload is not supported by handler")
+    ErrUnableParseRequest = errors.DefBadRequest("UnableParseRequest", "Unable to parse request")
 )
 
+// PanicError converts a recovered value into an error typed after the value,
+// marked as a panic.
+func PanicError(recovered interface{}) errors.Error {
+    switch cause := recovered.(type) {
+    case errors.Error:
+        return cause.WithPanic()
+    case error:
+        return errors.InternalError(GetErrorType(cause), cause.Error()).WithPanic()
+    }
+
+    return errors.InternalError(GetErrorType(recovered), fmt.Sprintf("%v", recovered)).WithPanic()
+}
+
 //import (
 //    "reflect"
 //)
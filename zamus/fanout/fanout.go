@@ -0,0 +1,190 @@
+package fanout
+
+import (
+    "context"
+    "encoding/json"
+    "strings"
+    "sync"
+
+    "github.com/aws/aws-lambda-go/events"
+    jsoniter "github.com/json-iterator/go"
+    "github.com/onedaycat/errors"
+    "github.com/onedaycat/zamus/zamus"
+)
+
+var jsonen = jsoniter.ConfigCompatibleWithStandardLibrary
+
+var ErrSubscriberFailed = errors.DefInternalError("SubscriberFailed", "One or more subscribers failed")
+
+type Subscriber func(ctx context.Context, source interface{}) (interface{}, error)
+
+type Subscription struct {
+    name    string
+    fn      Subscriber
+    retries int
+}
+
+// SetRetry retries only this subscriber, without running the others again.
+func (s *Subscription) SetRetry(times int) {
+    s.retries = times
+}
+
+// Delivery is the outcome of one subscriber.
+type Delivery struct {
+    Subscriber string            `json:"subscriber"`
+    Attempts   int               `json:"attempts"`
+    Data       interface{}       `json:"data,omitempty"`
+    Error      *errors.JSONError `json:"error,omitempty"`
+}
+
+func (d *Delivery) Failed() bool {
+    return d.Error != nil
+}
+
+// Result lists the deliveries in the order the subscribers were registered.
+type Result struct {
+    Deliveries []*Delivery `json:"deliveries"`
+}
+
+// Failed returns the names of the subscribers which failed.
+func (r *Result) Failed() []string {
+    var names []string
+    for _, d := range r.Deliveries {
+        if d.Failed() {
+            names = append(names, d.Subscriber)
+        }
+    }
+
+    return names
+}
+
+// Fanout is a zamus.Handler dispatching the parsed source to every
+// subscriber. A failing or panicking subscriber does not stop the others.
+type Fanout struct {
+    newSource     func() interface{}
+    subscriptions []*Subscription
+    sequential    bool
+    failOnError   bool
+}
+
+// New parses each payload into the value returned by newSource. A nil
+// newSource passes the raw json.RawMessage.
+func New(newSource func() interface{}) *Fanout {
+    return &Fanout{
+        newSource: newSource,
+    }
+}
+
+func NewSNS() *Fanout {
+    return New(func() interface{} { return &events.SNSEvent{} })
+}
+
+// NewEventBridge parses EventBridge and CloudWatch events.
+func NewEventBridge() *Fanout {
+    return New(func() interface{} { return &events.CloudWatchEvent{} })
+}
+
+// Subscribe adds a subscriber. Names identify the subscriber in the Result.
+func (f *Fanout) Subscribe(name string, fn Subscriber) *Subscription {
+    s := &Subscription{
+        name: name,
+        fn:   fn,
+    }
+    f.subscriptions = append(f.subscriptions, s)
+
+    return s
+}
+
+// SetSequential runs the subscribers one after another in registration
+// order instead of concurrently.
+func (f *Fanout) SetSequential(sequential bool) {
+    f.sequential = sequential
+}
+
+// SetFailOnError returns ErrSubscriberFailed along with the Result when any
+// subscriber fails, so the invocation fails as a whole. Retries configured
+// on zamus.Handle then run every subscriber again.
+func (f *Fanout) SetFailOnError(failOnError bool) {
+    f.failOnError = failOnError
+}
+
+func (f *Fanout) ParseSource(ctx context.Context, payload json.RawMessage) interface{} {
+    if f.newSource == nil {
+        return payload
+    }
+
+    source := f.newSource()
+    if err := jsonen.Unmarshal(payload, source); err != nil {
+        panic(errors.InternalError("UnableParseSource", "UnableParseSource: "+err.Error()))
+    }
+
+    return source
+}
+
+func (f *Fanout) ParseSources(ctx context.Context, payload json.RawMessage) interface{} {
+    panic(errors.InternalError("BatchInvokeNotAllowed", "Batch invoke not allowed"))
+}
+
+func (f *Fanout) BatchHandler(ctx context.Context, sources interface{}) (interface{}, error) {
+    panic(errors.InternalError("BatchInvokeNotAllowed", "Batch invoke not allowed"))
+}
+
+func (f *Fanout) Handler(ctx context.Context, source interface{}) (interface{}, error) {
+    result := &Result{
+        Deliveries: make([]*Delivery, len(f.subscriptions)),
+    }
+
+    if f.sequential {
+        for i, s := range f.subscriptions {
+            result.Deliveries[i] = deliver(ctx, s, source)
+        }
+    } else {
+        var wg sync.WaitGroup
+        for i, s := range f.subscriptions {
+            wg.Add(1)
+            go func(i int, s *Subscription) {
+                defer wg.Done()
+                result.Deliveries[i] = deliver(ctx, s, source)
+            }(i, s)
+        }
+        wg.Wait()
+    }
+
+    if failed := result.Failed(); f.failOnError && len(failed) > 0 {
+        return result, ErrSubscriberFailed.Newf("Subscribers failed: %s", strings.Join(failed, ", ")).WithInput(result)
+    }
+
+    return result, nil
+}
+
+func deliver(ctx context.Context, s *Subscription, source interface{}) *Delivery {
+    d := &Delivery{
+        Subscriber: s.name,
+    }
+    retries := zamus.NewRetries(s.retries)
+
+    for {
+        d.Attempts++
+        data, err := call(ctx, s, source)
+        if err == nil {
+            d.Data = data
+            d.Error = nil
+            return d
+        }
+
+        d.Error = zamus.NewResultError(err)
+        if ctx.Err() != nil || !retries.Retry() {
+            return d
+        }
+    }
+}
+
+func call(ctx context.Context, s *Subscription, source interface{}) (data interface{}, err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            err = zamus.PanicError(r)
+        }
+    }()
+
+    return s.fn(ctx, source)
+}
@@ -0,0 +1,76 @@
+package fanout
+
+import (
+    "context"
+    "sync/atomic"
+    "testing"
+
+    "github.com/aws/aws-lambda-go/events"
+    "github.com/onedaycat/errors"
+    "github.com/onedaycat/zamus/zamus"
+    "github.com/stretchr/testify/require"
+)
+
+const snsPayload = `{"Records":[{"EventSource":"aws:sns","Sns":{"MessageId":"1","Message":"hello"}}]}`
+
+func message(source interface{}) string {
+    return source.(*events.SNSEvent).Records[0].SNS.Message
+}
+
+func TestFanout(t *testing.T) {
+    for _, sequential := range []bool{false, true} {
+        f := NewSNS()
+        f.SetSequential(sequential)
+
+        var flaky int32
+        f.Subscribe("email", func(ctx context.Context, source interface{}) (interface{}, error) {
+            return "email " + message(source), nil
+        })
+        f.Subscribe("audit", func(ctx context.Context, source interface{}) (interface{}, error) {
+            return nil, errors.InternalError("AuditDown", "audit is down")
+        }).SetRetry(1)
+        f.Subscribe("search", func(ctx context.Context, source interface{}) (interface{}, error) {
+            if atomic.AddInt32(&flaky, 1) == 1 {
+                return nil, errors.InternalError("Busy", "busy")
+            }
+            return "indexed", nil
+        }).SetRetry(2)
+        f.Subscribe("cache", func(ctx context.Context, source interface{}) (interface{}, error) {
+            panic("boom")
+        })
+
+        h := zamus.New(f)
+        result, err := h.Invoke(context.Background(), []byte(snsPayload))
+
+        require.NoError(t, err)
+        require.Equal(t, &Result{Deliveries: []*Delivery{
+            {Subscriber: "email", Attempts: 1, Data: "email hello"},
+            {Subscriber: "audit", Attempts: 2, Error: &errors.JSONError{Code: "AuditDown", Message: "audit is down", ErrType: errors.InternalErrorType}},
+            {Subscriber: "search", Attempts: 2, Data: "indexed"},
+            {Subscriber: "cache", Attempts: 1, Error: &errors.JSONError{Code: "string", Message: "boom", ErrType: errors.InternalErrorType}},
+        }}, result)
+        require.Equal(t, []string{"audit", "cache"}, result.(*Result).Failed())
+    }
+}
+
+func TestFanoutFailOnError(t *testing.T) {
+    var called int32
+    f := New(nil)
+    f.SetFailOnError(true)
+    f.Subscribe("ok", func(ctx context.Context, source interface{}) (interface{}, error) {
+        atomic.AddInt32(&called, 1)
+        return nil, nil
+    })
+    f.Subscribe("fail", func(ctx context.Context, source interface{}) (interface{}, error) {
+        return nil, errors.InternalError("code1", "msg1")
+    })
+
+    h := zamus.New(f)
+    h.SetRetry(1)
+    result, err := h.Invoke(context.Background(), []byte(`{"id":"1"}`))
+
+    require.Equal(t, "SubscriberFailed: Subscribers failed: fail", err.Error())
+    require.True(t, ErrSubscriberFailed.Is(err.(errors.Error)))
+    require.Equal(t, []string{"fail"}, result.(*Result).Failed())
+    require.Equal(t, int32(2), atomic.LoadInt32(&called))
+}
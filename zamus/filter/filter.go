@@ -0,0 +1,184 @@
+package filter
+
+import (
+    "context"
+    "encoding/base64"
+    "encoding/json"
+
+    "github.com/onedaycat/errors"
+    "github.com/onedaycat/zamus/zamus"
+)
+
+var ErrInvalidPattern = errors.DefInternalError("InvalidPattern", "Invalid filter pattern")
+
+// Filter keeps the events or records matching any of its patterns, as the
+// filter criteria of a Lambda event source mapping do.
+type Filter struct {
+    patterns []*Pattern
+}
+
+// New compiles the patterns once, so it should be called at cold start.
+func New(patterns ...string) (*Filter, error) {
+    f := &Filter{}
+    for _, pattern := range patterns {
+        p, err := Compile(pattern)
+        if err != nil {
+            return nil, err
+        }
+        f.patterns = append(f.patterns, p)
+    }
+
+    return f, nil
+}
+
+func MustNew(patterns ...string) *Filter {
+    f, err := New(patterns...)
+    if err != nil {
+        panic(err)
+    }
+
+    return f
+}
+
+// Match reports whether an event decoded from JSON matches any pattern. A
+// filter without patterns matches everything.
+func (f *Filter) Match(event interface{}) bool {
+    if len(f.patterns) == 0 {
+        return true
+    }
+
+    for _, p := range f.patterns {
+        if p.Match(event) {
+            return true
+        }
+    }
+
+    return false
+}
+
+// MatchRecord matches one SQS, Kinesis or DynamoDB stream record the way
+// Lambda does: an SQS body and Kinesis data are matched as JSON when they
+// hold JSON. Records which are not JSON never match.
+func (f *Filter) MatchRecord(record json.RawMessage) bool {
+    view, err := RecordView(record)
+    if err != nil {
+        return false
+    }
+
+    return f.Match(view)
+}
+
+// Middleware drops the records of the payload which do not match before
+// the payload is parsed. A payload without Records is matched as a whole.
+// When nothing is left the handler is not called and the invocation
+// returns nil.
+func (f *Filter) Middleware(next zamus.InvokeHandler) zamus.InvokeHandler {
+    return func(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+        event := make(map[string]json.RawMessage)
+        if err := json.Unmarshal(payload, &event); err != nil {
+            return next(ctx, payload)
+        }
+
+        raw, ok := event["Records"]
+        if !ok {
+            var value interface{}
+            if err := decode(payload, &value); err != nil || !f.Match(value) {
+                return nil, nil
+            }
+            return next(ctx, payload)
+        }
+
+        var records []json.RawMessage
+        if err := json.Unmarshal(raw, &records); err != nil {
+            return next(ctx, payload)
+        }
+
+        kept := make([]json.RawMessage, 0, len(records))
+        for _, record := range records {
+            if f.MatchRecord(record) {
+                kept = append(kept, record)
+            }
+        }
+
+        switch len(kept) {
+        case 0:
+            return nil, nil
+        case len(records):
+            return next(ctx, payload)
+        }
+
+        raw, err := json.Marshal(kept)
+        if err != nil {
+            return next(ctx, payload)
+        }
+        event["Records"] = raw
+
+        filtered, err := json.Marshal(event)
+        if err != nil {
+            return next(ctx, payload)
+        }
+
+        return next(ctx, filtered)
+    }
+}
+
+// RecordView returns the record as Lambda filters see it. SQS bodies and
+// Kinesis data holding JSON are decoded, and the fields of a Kinesis record
+// are lifted to the top level.
+func RecordView(record json.RawMessage) (map[string]interface{}, error) {
+    view := make(map[string]interface{})
+    if err := decode(record, &view); err != nil {
+        return nil, err
+    }
+
+    switch view["eventSource"] {
+    case "aws:sqs":
+        if body, ok := view["body"].(string); ok {
+            view["body"] = decodeData([]byte(body), body)
+        }
+    case "aws:kinesis":
+        if kinesis, ok := view["kinesis"].(map[string]interface{}); ok {
+            for key, value := range kinesis {
+                view[key] = value
+            }
+        }
+
+        if data, ok := view["data"].(string); ok {
+            if decoded, err := base64.StdEncoding.DecodeString(data); err == nil {
+                view["data"] = decodeData(decoded, data)
+            }
+        }
+    }
+
+    return view, nil
+}
+
+func decodeData(data []byte, fallback string) interface{} {
+    var value interface{}
+    if err := decode(data, &value); err != nil {
+        return fallback
+    }
+
+    switch value.(type) {
+    case map[string]interface{}, []interface{}:
+        return value
+    }
+
+    return fallback
+}
+
+// Test reports whether a record or event matches a pattern. It is meant for
+// unit tests of the patterns deployed on event source mappings.
+func Test(pattern string, record string) (bool, error) {
+    f, err := New(pattern)
+    if err != nil {
+        return false, err
+    }
+
+    view, err := RecordView(json.RawMessage(record))
+    if err != nil {
+        return false, err
+    }
+
+    return f.Match(view), nil
+}
@@ -0,0 +1,107 @@
+package filter
+
+import (
+    "context"
+    "encoding/json"
+    "testing"
+
+    "github.com/aws/aws-lambda-go/events"
+    "github.com/onedaycat/zamus/zamus"
+    "github.com/onedaycat/zamus/zamus/source"
+    "github.com/stretchr/testify/require"
+)
+
+func TestFilterRecords(t *testing.T) {
+    var received []string
+    h := zamus.New(source.NewSQSHandler(func(ctx context.Context, src *events.SQSEvent) (interface{}, error) {
+        received = nil
+        for _, record := range src.Records {
+            received = append(received, record.MessageId)
+        }
+        return "handled", nil
+    }))
+    h.Use(MustNew(
+        `{"body":{"status":["ACTIVE"]}}`,
+        `{"messageAttributes":{"priority":{"stringValue":["high"]}}}`,
+    ).Middleware)
+
+    tests := []struct {
+        name     string
+        payload  string
+        result   interface{}
+        received []string
+    }{
+        {
+            name: "Drop some",
+            payload: `{"Records":[
+                {"messageId":"1","eventSource":"aws:sqs","body":"{\"status\":\"ACTIVE\"}"},
+                {"messageId":"2","eventSource":"aws:sqs","body":"{\"status\":\"DELETED\"}"},
+                {"messageId":"3","eventSource":"aws:sqs","body":"plain text","messageAttributes":{"priority":{"stringValue":"high"}}}
+            ]}`,
+            result:   "handled",
+            received: []string{"1", "3"},
+        },
+        {
+            name: "Drop all",
+            payload: `{"Records":[
+                {"messageId":"4","eventSource":"aws:sqs","body":"plain text"}
+            ]}`,
+            result:   nil,
+            received: nil,
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            received = nil
+
+            result, err := h.Invoke(context.Background(), []byte(tt.payload))
+
+            require.NoError(t, err)
+            require.Equal(t, tt.result, result)
+            require.Equal(t, tt.received, received)
+        })
+    }
+}
+
+func TestFilterEvent(t *testing.T) {
+    called := 0
+    h := zamus.New(source.NewJSONHandler(func(ctx context.Context, src json.RawMessage) (interface{}, error) {
+        called++
+        return nil, nil
+    }))
+    h.Use(MustNew(`{"detail-type":[{"prefix":"Order"}]}`).Middleware)
+
+    _, _ = h.Invoke(context.Background(), []byte(`{"detail-type":"OrderPlaced"}`))
+    _, _ = h.Invoke(context.Background(), []byte(`{"detail-type":"UserCreated"}`))
+
+    require.Equal(t, 1, called)
+}
+
+func TestRecordView(t *testing.T) {
+    tests := []struct {
+        name    string
+        pattern string
+        record  string
+    }{
+        {
+            name:    "Kinesis",
+            pattern: `{"partitionKey":["p1"],"data":{"temperature":[{"numeric":[">",30]}]}}`,
+            record:  `{"eventSource":"aws:kinesis","kinesis":{"partitionKey":"p1","data":"eyJ0ZW1wZXJhdHVyZSI6MzV9"}}`,
+        },
+        {
+            name:    "DynamoDB",
+            pattern: `{"eventName":["INSERT"],"dynamodb":{"NewImage":{"status":{"S":["ACTIVE"]}}}}`,
+            record:  `{"eventSource":"aws:dynamodb","eventName":"INSERT","dynamodb":{"NewImage":{"status":{"S":"ACTIVE"}}}}`,
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            match, err := Test(tt.pattern, tt.record)
+
+            require.NoError(t, err)
+            require.True(t, match)
+        })
+    }
+}
@@ -0,0 +1,410 @@
+package filter
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "sort"
+    "strings"
+)
+
+// Pattern is a compiled Lambda event filter pattern. Every field of the
+// pattern must match; the values listed for a field are alternatives.
+// Supported values are literals, null, prefix, suffix, equals-ignore-case,
+// anything-but, numeric, exists and $or.
+type Pattern struct {
+    root *object
+}
+
+type object struct {
+    fields []*field
+    or     []*object
+}
+
+type field struct {
+    name     string
+    object   *object
+    matchers []matcher
+}
+
+type matcher interface {
+    match(value interface{}, exists bool) bool
+}
+
+// Compile parses a filter pattern such as
+//
+//    {"body": {"status": ["active"], "price": [{"numeric": [">", 10]}]}}
+func Compile(pattern string) (*Pattern, error) {
+    var value interface{}
+    if err := decode([]byte(pattern), &value); err != nil {
+        return nil, ErrInvalidPattern.Newf("Invalid filter pattern: %s", err.Error())
+    }
+
+    root, ok := value.(map[string]interface{})
+    if !ok {
+        return nil, ErrInvalidPattern.Newf("Filter pattern must be an object")
+    }
+
+    compiled, err := compileObject(root)
+    if err != nil {
+        return nil, ErrInvalidPattern.Newf("Invalid filter pattern: %s", err.Error())
+    }
+
+    return &Pattern{root: compiled}, nil
+}
+
+func MustCompile(pattern string) *Pattern {
+    p, err := Compile(pattern)
+    if err != nil {
+        panic(err)
+    }
+
+    return p
+}
+
+// Match reports whether an event decoded from JSON matches the pattern.
+func (p *Pattern) Match(event interface{}) bool {
+    m, ok := event.(map[string]interface{})
+    if !ok {
+        return false
+    }
+
+    return p.root.match(m)
+}
+
+func (p *Pattern) MatchJSON(event []byte) (bool, error) {
+    var value interface{}
+    if err := decode(event, &value); err != nil {
+        return false, err
+    }
+
+    return p.Match(value), nil
+}
+
+func decode(data []byte, value interface{}) error {
+    dec := json.NewDecoder(bytes.NewReader(data))
+    dec.UseNumber()
+
+    return dec.Decode(value)
+}
+
+func compileObject(pattern map[string]interface{}) (*object, error) {
+    names := make([]string, 0, len(pattern))
+    for name := range pattern {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    o := &object{}
+    for _, name := range names {
+        value := pattern[name]
+        if name == "$or" {
+            alternatives, ok := value.([]interface{})
+            if !ok || len(alternatives) < 2 {
+                return nil, fmt.Errorf("$or needs at least two patterns")
+            }
+
+            for _, alternative := range alternatives {
+                m, ok := alternative.(map[string]interface{})
+                if !ok {
+                    return nil, fmt.Errorf("$or needs object patterns")
+                }
+
+                compiled, err := compileObject(m)
+                if err != nil {
+                    return nil, err
+                }
+                o.or = append(o.or, compiled)
+            }
+            continue
+        }
+
+        f := &field{name: name}
+        switch v := value.(type) {
+        case map[string]interface{}:
+            compiled, err := compileObject(v)
+            if err != nil {
+                return nil, err
+            }
+            f.object = compiled
+        case []interface{}:
+            if len(v) == 0 {
+                return nil, fmt.Errorf("%s must list at least one value", name)
+            }
+
+            for _, item := range v {
+                m, err := compileMatcher(item)
+                if err != nil {
+                    return nil, fmt.Errorf("%s: %s", name, err.Error())
+                }
+                f.matchers = append(f.matchers, m)
+            }
+        default:
+            return nil, fmt.Errorf("%s must be an object or an array", name)
+        }
+
+        o.fields = append(o.fields, f)
+    }
+
+    return o, nil
+}
+
+func compileMatcher(item interface{}) (matcher, error) {
+    rule, ok := item.(map[string]interface{})
+    if !ok {
+        return literal{item}, nil
+    }
+
+    if len(rule) != 1 {
+        return nil, fmt.Errorf("rule must have exactly one operator")
+    }
+
+    for op, arg := range rule {
+        switch op {
+        case "prefix", "suffix", "equals-ignore-case":
+            s, ok := arg.(string)
+            if !ok {
+                return nil, fmt.Errorf("%s needs a string", op)
+            }
+            return stringMatcher{op, s}, nil
+        case "exists":
+            b, ok := arg.(bool)
+            if !ok {
+                return nil, fmt.Errorf("exists needs a boolean")
+            }
+            return exists(b), nil
+        case "numeric":
+            return compileNumeric(arg)
+        case "anything-but":
+            return compileAnythingBut(arg)
+        default:
+            return nil, fmt.Errorf("unknown operator %s", op)
+        }
+    }
+
+    return nil, nil
+}
+
+func compileNumeric(arg interface{}) (matcher, error) {
+    items, ok := arg.([]interface{})
+    if !ok || len(items) == 0 || len(items)%2 != 0 || len(items) > 4 {
+        return nil, fmt.Errorf("numeric needs one or two operator and number pairs")
+    }
+
+    var n numeric
+    for i := 0; i < len(items); i += 2 {
+        op, ok := items[i].(string)
+        if !ok {
+            return nil, fmt.Errorf("numeric operator must be a string")
+        }
+
+        switch op {
+        case "=", "<", "<=", ">", ">=":
+        default:
+            return nil, fmt.Errorf("unknown numeric operator %s", op)
+        }
+
+        value, ok := toFloat(items[i+1])
+        if !ok {
+            return nil, fmt.Errorf("numeric %s needs a number", op)
+        }
+
+        n = append(n, condition{op, value})
+    }
+
+    return n, nil
+}
+
+func compileAnythingBut(arg interface{}) (matcher, error) {
+    switch v := arg.(type) {
+    case map[string]interface{}:
+        inner, err := compileMatcher(v)
+        if err != nil {
+            return nil, err
+        }
+        if _, ok := inner.(stringMatcher); !ok {
+            return nil, fmt.Errorf("anything-but only accepts prefix, suffix or equals-ignore-case rules")
+        }
+        return anythingBut{inner}, nil
+    case []interface{}:
+        var values literals
+        for _, item := range v {
+            values = append(values, literal{item})
+        }
+        return anythingBut{values}, nil
+    }
+
+    return anythingBut{literal{arg}}, nil
+}
+
+type literal struct {
+    value interface{}
+}
+
+func (l literal) match(value interface{}, exists bool) bool {
+    if !exists {
+        return false
+    }
+
+    if l.value == nil {
+        return value == nil
+    }
+
+    if want, ok := toFloat(l.value); ok {
+        got, ok := toFloat(value)
+        return ok && got == want
+    }
+
+    return l.value == value
+}
+
+type literals []literal
+
+func (ls literals) match(value interface{}, exists bool) bool {
+    for _, l := range ls {
+        if l.match(value, exists) {
+            return true
+        }
+    }
+
+    return false
+}
+
+type stringMatcher struct {
+    op    string
+    value string
+}
+
+func (m stringMatcher) match(value interface{}, exists bool) bool {
+    s, ok := value.(string)
+    if !ok || !exists {
+        return false
+    }
+
+    switch m.op {
+    case "prefix":
+        return strings.HasPrefix(s, m.value)
+    case "suffix":
+        return strings.HasSuffix(s, m.value)
+    }
+
+    return strings.EqualFold(s, m.value)
+}
+
+type exists bool
+
+func (e exists) match(value interface{}, found bool) bool {
+    return bool(e) == found
+}
+
+type condition struct {
+    op    string
+    value float64
+}
+
+type numeric []condition
+
+func (n numeric) match(value interface{}, exists bool) bool {
+    got, ok := toFloat(value)
+    if !ok || !exists {
+        return false
+    }
+
+    for _, c := range n {
+        var matched bool
+        switch c.op {
+        case "=":
+            matched = got == c.value
+        case "<":
+            matched = got < c.value
+        case "<=":
+            matched = got <= c.value
+        case ">":
+            matched = got > c.value
+        case ">=":
+            matched = got >= c.value
+        }
+
+        if !matched {
+            return false
+        }
+    }
+
+    return true
+}
+
+type anythingBut struct {
+    inner matcher
+}
+
+func (a anythingBut) match(value interface{}, exists bool) bool {
+    return exists && !a.inner.match(value, exists)
+}
+
+func toFloat(value interface{}) (float64, bool) {
+    n, ok := value.(json.Number)
+    if !ok {
+        return 0, false
+    }
+
+    f, err := n.Float64()
+
+    return f, err == nil
+}
+
+func (o *object) match(event map[string]interface{}) bool {
+    for _, f := range o.fields {
+        if !f.match(event) {
+            return false
+        }
+    }
+
+    if len(o.or) == 0 {
+        return true
+    }
+
+    for _, alternative := range o.or {
+        if alternative.match(event) {
+            return true
+        }
+    }
+
+    return false
+}
+
+func (f *field) match(event map[string]interface{}) bool {
+    value, found := event[f.name]
+
+    if f.object != nil {
+        nested, ok := value.(map[string]interface{})
+        if !ok {
+            // An object pattern can still match fields which are all
+            // required to be missing.
+            return f.object.match(map[string]interface{}{})
+        }
+        return f.object.match(nested)
+    }
+
+    // Arrays match when any of their elements does.
+    if items, ok := value.([]interface{}); ok {
+        for _, m := range f.matchers {
+            if _, ok := m.(exists); ok && m.match(value, found) {
+                return true
+            }
+            for _, item := range items {
+                if m.match(item, true) {
+                    return true
+                }
+            }
+        }
+        return false
+    }
+
+    for _, m := range f.matchers {
+        if m.match(value, found) {
+            return true
+        }
+    }
+
+    return false
+}
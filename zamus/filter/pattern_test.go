@@ -0,0 +1,83 @@
+package filter
+
+import (
+    "testing"
+
+    "github.com/stretchr/testify/require"
+)
+
+func TestPattern(t *testing.T) {
+    event := `{
+        "source": "orders",
+        "detail": {
+            "status": "ACTIVE",
+            "region": "us-east-1",
+            "file": "report.pdf",
+            "price": 12.5,
+            "count": 3,
+            "tags": ["a", "b"],
+            "coupon": null
+        }
+    }`
+
+    tests := []struct {
+        name    string
+        pattern string
+        match   bool
+    }{
+        {"Literal", `{"source":["orders"]}`, true},
+        {"Literal alternatives", `{"source":["users","orders"]}`, true},
+        {"Literal mismatch", `{"source":["users"]}`, false},
+        {"Nested", `{"detail":{"status":["ACTIVE"],"count":[3]}}`, true},
+        {"Number literal", `{"detail":{"price":[12.50]}}`, true},
+        {"Null", `{"detail":{"coupon":[null]}}`, true},
+        {"Array value", `{"detail":{"tags":["b"]}}`, true},
+        {"Prefix", `{"detail":{"region":[{"prefix":"us-"}]}}`, true},
+        {"Prefix mismatch", `{"detail":{"region":[{"prefix":"eu-"}]}}`, false},
+        {"Suffix", `{"detail":{"file":[{"suffix":".pdf"}]}}`, true},
+        {"Equals ignore case", `{"detail":{"status":[{"equals-ignore-case":"active"}]}}`, true},
+        {"Anything but", `{"detail":{"status":[{"anything-but":"DELETED"}]}}`, true},
+        {"Anything but list", `{"detail":{"status":[{"anything-but":["ACTIVE","DELETED"]}]}}`, false},
+        {"Anything but prefix", `{"detail":{"region":[{"anything-but":{"prefix":"us-"}}]}}`, false},
+        {"Anything but missing", `{"detail":{"missing":[{"anything-but":"x"}]}}`, false},
+        {"Numeric range", `{"detail":{"price":[{"numeric":[">",10,"<=",20]}]}}`, true},
+        {"Numeric range mismatch", `{"detail":{"price":[{"numeric":[">",10,"<",12]}]}}`, false},
+        {"Numeric equal", `{"detail":{"count":[{"numeric":["=",3]}]}}`, true},
+        {"Numeric on string", `{"detail":{"status":[{"numeric":[">",0]}]}}`, false},
+        {"Exists", `{"detail":{"status":[{"exists":true}]}}`, true},
+        {"Exists mismatch", `{"detail":{"missing":[{"exists":true}]}}`, false},
+        {"Not exists", `{"detail":{"missing":[{"exists":false}]}}`, true},
+        {"Not exists on missing object", `{"other":{"missing":[{"exists":false}]}}`, true},
+        {"Or", `{"$or":[{"source":["users"]},{"detail":{"count":[{"numeric":[">",2]}]}}]}`, true},
+        {"Or mismatch", `{"$or":[{"source":["users"]},{"detail":{"count":[{"numeric":[">",5]}]}}]}`, false},
+        {"Or with fields", `{"source":["users"],"$or":[{"detail":{"count":[3]}},{"detail":{"price":[1]}}]}`, false},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            match, err := Test(tt.pattern, event)
+
+            require.NoError(t, err)
+            require.Equal(t, tt.match, match)
+        })
+    }
+}
+
+func TestPatternInvalid(t *testing.T) {
+    patterns := []string{
+        `[]`,
+        `{"source":"orders"}`,
+        `{"source":[]}`,
+        `{"source":[{"prefix":1}]}`,
+        `{"source":[{"unknown":"a"}]}`,
+        `{"source":[{"numeric":[">"]}]}`,
+        `{"source":[{"numeric":["!",1]}]}`,
+        `{"source":[{"anything-but":{"numeric":[">",1]}}]}`,
+        `{"$or":[{"source":["a"]}]}`,
+    }
+
+    for _, pattern := range patterns {
+        _, err := Compile(pattern)
+        require.Error(t, err, pattern)
+    }
+}
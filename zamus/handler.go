@@ -1,17 +1,26 @@
 package zamus
 
 import (
+    "bytes"
     "context"
     "encoding/json"
-    "fmt"
+    "reflect"
+    "runtime/debug"
+    "sync"
+    "time"
 
     jsoniter "github.com/json-iterator/go"
-    "github.com/onedaycat/errors"
 )
 
 const (
     firstCharArray  = 91
     firstCharObject = 123
+    firstCharString = 34
+)
+
+var (
+    byteOrderMark = []byte{0xEF, 0xBB, 0xBF}
+    nullPayload   = []byte("null")
 )
 
 var jsonen = jsoniter.ConfigCompatibleWithStandardLibrary
@@ -22,9 +31,18 @@ type PreHandler func(ctx context.Context, payload json.RawMessage, src interface
 type PostHandler func(ctx context.Context, payload json.RawMessage, src interface{}, res interface{}, err error) (interface{}, error)
 type BatchPreHandler func(ctx context.Context, payload json.RawMessage, src interface{}) (interface{}, error)
 type BatchPostHandler func(ctx context.Context, payload json.RawMessage, src interface{}, res interface{}, err error) (interface{}, error)
-type PanicHandler func(ctx context.Context, payload json.RawMessage, err error) (interface{}, error)
+type PanicHandler func(ctx context.Context, info *PanicInfo) (interface{}, error)
 type RetryFailedHandler func(ctx context.Context, payload json.RawMessage, src interface{}, err error) (interface{}, error)
 
+// InvokeHandler has the same signature as Handle.Invoke and is what a
+// Middleware wraps.
+type InvokeHandler func(ctx context.Context, payload json.RawMessage) (interface{}, error)
+
+// Middleware wraps the whole invocation, before the payload is parsed and
+// after panics are recovered, so it can short-circuit or observe every
+// outcome of Invoke.
+type Middleware func(next InvokeHandler) InvokeHandler
+
 type Handler interface {
     ParseSource(ctx context.Context, payload json.RawMessage) interface{}
     ParseSources(ctx context.Context, payload json.RawMessage) interface{}
@@ -32,6 +50,12 @@ type Handler interface {
     BatchHandler(ctx context.Context, sources interface{}) (interface{}, error)
 }
 
+// ScalarHandler is implemented by a Handler accepting string, number and
+// boolean payloads. The parsed scalar is passed to Handler.
+type ScalarHandler interface {
+    ParseScalar(ctx context.Context, payload json.RawMessage) interface{}
+}
+
 type Handle struct {
     retries           *Retries
     handle            Handler
@@ -41,6 +65,13 @@ type Handle struct {
     batchPostHandlers []BatchPostHandler
     panicHandler      PanicHandler
     retryHandler      RetryFailedHandler
+    middlewares       []Middleware
+    timeout           time.Duration
+    defaultPayload    json.RawMessage
+    chunkSize         int
+    chunkConcurrency  int
+    deadlineThreshold time.Duration
+    observers         []Observer
 }
 
 func New(handle Handler) *Handle {
@@ -54,31 +85,166 @@ func (h *Handle) SetRetry(times int) {
     h.retries.SetTimes(times)
 }
 
-func (h *Handle) Invoke(ctx context.Context, payload json.RawMessage) (result interface{}, err error) {
+// SetTimeout limits each call to Handler or BatchHandler. An attempt running
+// longer is abandoned with ErrAttemptTimeout and retried while the invocation
+// deadline has not passed. Zero disables the limit.
+//
+// An abandoned attempt is not stopped: its goroutine keeps running while the
+// retry calls the handler again, so both may have side effects. Handlers must
+// honor the cancellation of the context they are given, and stop there.
+func (h *Handle) SetTimeout(timeout time.Duration) {
+    h.timeout = timeout
+}
+
+// SetBatchChunk splits a batch into chunks of at most size sources and calls
+// BatchHandler once per chunk, running up to concurrency chunks at a time.
+// Each chunk is retried on its own and the chunk results, which must be
+// slices holding one result per source, are joined in the original order.
+// Zero size disables chunking.
+func (h *Handle) SetBatchChunk(size, concurrency int) {
+    h.chunkSize = size
+    h.chunkConcurrency = concurrency
+}
+
+// SetDeadlineThreshold stops starting batch work once less than threshold is
+// left before the deadline of the invocation: no retry is attempted and the
+// sources of chunks not started yet are reported as ErrDeadlineNear results,
+// or in a PartialBatch when BatchHandler does not return []*Result. Batch
+// handlers honour it through NearDeadline. Zero disables the threshold.
+func (h *Handle) SetDeadlineThreshold(threshold time.Duration) {
+    h.deadlineThreshold = threshold
+}
+
+// SetDefaultPayload is parsed instead of an empty or null payload, which
+// otherwise fails with ErrEmptyPayload.
+func (h *Handle) SetDefaultPayload(payload json.RawMessage) {
+    h.defaultPayload = payload
+}
+
+func (h *Handle) Invoke(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+    ctx, inv := withInvocation(ctx, payload)
+    inv.defaultPayload = h.defaultPayload
+    invoke := h.invoke
+    for i := len(h.middlewares) - 1; i >= 0; i-- {
+        invoke = h.middlewares[i](invoke)
+    }
+
+    if len(h.observers) == 0 {
+        return invoke(ctx, payload)
+    }
+
+    start := time.Now()
+    result, err := invoke(ctx, payload)
+    event := &CompleteEvent{
+        Result:   result,
+        Err:      err,
+        Attempts: inv.Attempts,
+        Duration: time.Since(start),
+    }
+
+    for _, o := range h.observers {
+        o.OnComplete(ctx, event)
+    }
+
+    return result, err
+}
+
+func (h *Handle) invoke(ctx context.Context, payload json.RawMessage) (result interface{}, err error) {
     var src interface{}
     var isBatch bool
     defer h.recovery(ctx, payload, &result, &err)
 
-    src, isBatch = h.parseSource(ctx, payload)
+    payload, src, isBatch, err = h.parse(ctx, payload)
+    if err != nil {
+        return nil, err
+    }
+
     result, err = h.Run(ctx, payload, src, isBatch)
 
     return result, err
 }
 
+// parse also ends the parse for observers when ParseSource panics, which is
+// how handlers report a payload they cannot decode.
+func (h *Handle) parse(ctx context.Context, payload json.RawMessage) (json.RawMessage, interface{}, bool, error) {
+    for _, o := range h.observers {
+        o.OnParseStart(ctx, payload)
+    }
+    start := time.Now()
+
+    ended := false
+    end := func(src interface{}, isBatch bool, err error) {
+        ended = true
+        for _, o := range h.observers {
+            o.OnParseEnd(ctx, &ParseEvent{
+                Source:   src,
+                IsBatch:  isBatch,
+                Duration: time.Since(start),
+                Err:      err,
+            })
+        }
+    }
+
+    defer func() {
+        if ended {
+            return
+        }
+
+        if r := recover(); r != nil {
+            stack := debug.Stack()
+            end(nil, false, PanicError(r))
+            panic(&attemptPanic{r, stack})
+        }
+    }()
+
+    payload, err := preparePayload(payload, h.defaultPayload)
+    if err != nil {
+        end(nil, false, err)
+        return payload, nil, false, err
+    }
+
+    src, isBatch, err := h.parseSource(ctx, payload)
+    end(src, isBatch, err)
+
+    return payload, src, isBatch, err
+}
+
 func (h *Handle) Run(ctx context.Context, payload json.RawMessage, src interface{}, isBatch bool) (interface{}, error) {
     h.retries.Reset()
 
+    inv := InvocationFromContext(ctx)
+    if inv == nil {
+        ctx, inv = withInvocation(ctx, payload)
+    }
+    inv.Payload = payload
+    inv.Source = src
+    inv.IsBatch = isBatch
+    inv.stopAt = h.deadlineThreshold
+
     if isBatch {
         result, err := h.doBatchPreHandler(ctx, payload, src)
         if err != nil || result != nil {
+            h.observeShortCircuit(ctx, result, err, true)
             return result, err
         }
+        if h.canChunk(src) {
+            result, err = h.runChunks(ctx, inv, src)
+            if err != nil && h.retryHandler != nil && h.retries.times > 0 {
+                result, err = h.retryHandler(ctx, payload, src, err)
+            }
+
+            return h.doBatchPostHandler(ctx, payload, src, result, err)
+        }
+
     RetryBatchHandler:
-        result, err = h.handle.BatchHandler(ctx, src)
+        inv.Attempts++
+        result, err = h.callBatchHandler(ctx, inv.Attempts, src)
         if err != nil {
-            if h.retries.Retry() {
+            if h.canRetry(ctx) {
+                h.observeRetry(ctx, inv.Attempts, true, err, true)
                 goto RetryBatchHandler
             } else {
+                h.observeRetry(ctx, inv.Attempts, true, err, false)
                 if h.retryHandler != nil && h.retries.times > 0 {
                     result, err = h.retryHandler(ctx, payload, src, err)
                 }
@@ -92,15 +258,19 @@ func (h *Handle) Run(ctx context.Context, payload json.RawMessage, src interface
 
     result, err := h.doPreHandler(ctx, payload, src)
     if err != nil || result != nil {
+        h.observeShortCircuit(ctx, result, err, false)
         return result, err
     }
 
 RetryHandler:
-    result, err = h.handle.Handler(ctx, src)
+    inv.Attempts++
+    result, err = h.callHandler(ctx, inv.Attempts, src)
     if err != nil {
-        if h.retries.Retry() {
+        if h.canRetry(ctx) {
+            h.observeRetry(ctx, inv.Attempts, false, err, true)
             goto RetryHandler
         } else {
+            h.observeRetry(ctx, inv.Attempts, false, err, false)
             if h.retryHandler != nil && h.retries.times > 0 {
                 result, err = h.retryHandler(ctx, payload, src, err)
             }
@@ -112,6 +282,12 @@ RetryHandler:
     return result, err
 }
 
+// Use appends middlewares around Invoke. The first registered middleware is
+// the outermost one.
+func (h *Handle) Use(middlewares ...Middleware) {
+    h.middlewares = append(h.middlewares, middlewares...)
+}
+
 func (h *Handle) RegisterPreHandler(preHandlers ...PreHandler) {
     h.preHandlers = append(h.preHandlers, preHandlers...)
 }
@@ -180,32 +356,364 @@ func (h *Handle) doBatchPostHandler(ctx context.Context, payload json.RawMessage
     return result, err
 }
 
-func (h *Handle) parseSource(ctx context.Context, payload json.RawMessage) (interface{}, bool) {
+func (h *Handle) canRetry(ctx context.Context) bool {
+    if ctx.Err() != nil || NearDeadline(ctx) {
+        return false
+    }
+
+    return h.retries.Retry()
+}
+
+func (h *Handle) callHandler(ctx context.Context, attempt int, src interface{}) (interface{}, error) {
+    return h.observeAttempt(ctx, attempt, false, func() (interface{}, error) {
+        if h.timeout <= 0 {
+            return h.handle.Handler(ctx, src)
+        }
+
+        return h.withTimeout(ctx, func(ctx context.Context) (interface{}, error) {
+            return h.handle.Handler(ctx, src)
+        })
+    })
+}
+
+func (h *Handle) callBatchHandler(ctx context.Context, attempt int, src interface{}) (interface{}, error) {
+    return h.observeAttempt(ctx, attempt, true, func() (interface{}, error) {
+        if h.timeout <= 0 {
+            return h.handle.BatchHandler(ctx, src)
+        }
+
+        return h.withTimeout(ctx, func(ctx context.Context) (interface{}, error) {
+            return h.handle.BatchHandler(ctx, src)
+        })
+    })
+}
+
+type chunk struct {
+    result    interface{}
+    err       error
+    attempts  int
+    size      int
+    unstarted int
+}
+
+func (h *Handle) canChunk(src interface{}) bool {
+    if h.chunkSize <= 0 {
+        return false
+    }
+
+    v := reflect.ValueOf(src)
+
+    return v.Kind() == reflect.Slice && v.Len() > h.chunkSize
+}
+
+func (h *Handle) runChunks(ctx context.Context, inv *Invocation, src interface{}) (interface{}, error) {
+    sources := reflect.ValueOf(src)
+    chunks := make([]*chunk, (sources.Len()+h.chunkSize-1)/h.chunkSize)
+    sourceAt := func(i int) interface{} {
+        end := (i + 1) * h.chunkSize
+        if end > sources.Len() {
+            end = sources.Len()
+        }
+
+        return sources.Slice(i*h.chunkSize, end).Interface()
+    }
+
+    unstarted := func(i int) *chunk {
+        return &chunk{unstarted: reflect.ValueOf(sourceAt(i)).Len()}
+    }
+
+    if h.chunkConcurrency <= 1 {
+        for i := range chunks {
+            if NearDeadline(ctx) {
+                chunks[i] = unstarted(i)
+                continue
+            }
+
+            chunks[i] = h.runChunk(ctx, sourceAt(i))
+            if chunks[i].err != nil {
+                break
+            }
+        }
+    } else {
+        var wg sync.WaitGroup
+        var mu sync.Mutex
+        var recovered *attemptPanic
+        sem := make(chan struct{}, h.chunkConcurrency)
+        for i := range chunks {
+            sem <- struct{}{}
+            if NearDeadline(ctx) {
+                chunks[i] = unstarted(i)
+                <-sem
+                continue
+            }
+
+            wg.Add(1)
+            go func(i int) {
+                defer func() {
+                    if r := recover(); r != nil {
+                        mu.Lock()
+                        if recovered == nil {
+                            ap, ok := r.(*attemptPanic)
+                            if !ok {
+                                ap = &attemptPanic{r, debug.Stack()}
+                            }
+                            recovered = ap
+                        }
+                        mu.Unlock()
+                    }
+                    <-sem
+                    wg.Done()
+                }()
+
+                chunks[i] = h.runChunk(ctx, sourceAt(i))
+            }(i)
+        }
+        wg.Wait()
+
+        if recovered != nil {
+            panic(recovered)
+        }
+    }
+
+    for _, c := range chunks {
+        if c != nil && c.attempts > inv.Attempts {
+            inv.Attempts = c.attempts
+        }
+    }
+
+    return joinChunks(chunks, sources.Len())
+}
+
+// runChunk calls BatchHandler with its own retry budget, so a failing chunk
+// does not run the others again.
+func (h *Handle) runChunk(ctx context.Context, src interface{}) *chunk {
+    retries := NewRetries(h.retries.times)
+    c := &chunk{size: reflect.ValueOf(src).Len()}
+    for {
+        c.attempts++
+        c.result, c.err = h.callBatchHandler(ctx, c.attempts, src)
+        if c.err == nil {
+            return c
+        }
+
+        if ctx.Err() != nil || NearDeadline(ctx) || !retries.Retry() {
+            h.observeRetry(ctx, c.attempts, true, c.err, false)
+            return c
+        }
+        h.observeRetry(ctx, c.attempts, true, c.err, true)
+    }
+}
+
+// joinChunks reports the sources of chunks not started before the deadline
+// as ErrDeadlineNear results when the other chunks return []*Result, and as
+// the Unprocessed indexes of a PartialBatch otherwise.
+func joinChunks(chunks []*chunk, size int) (interface{}, error) {
+    for _, c := range chunks {
+        if c != nil && c.err != nil {
+            return nil, c.err
+        }
+    }
+
+    var joined reflect.Value
+    var unprocessed []int
+    offset := 0
+    for i, c := range chunks {
+        if c.unstarted > 0 {
+            for n := 0; n < c.unstarted; n++ {
+                unprocessed = append(unprocessed, offset+n)
+            }
+            offset += c.unstarted
+            continue
+        }
+        offset += c.size
+
+        if c.result == nil {
+            return nil, ErrChunkResult.Newf("Chunk %d result is nil", i)
+        }
+
+        v := reflect.ValueOf(c.result)
+        if v.Kind() != reflect.Slice {
+            return nil, ErrChunkResult.Newf("Chunk %d result is %s, not a slice", i, v.Type())
+        }
+
+        if v.Len() != c.size {
+            return nil, ErrChunkResult.Newf("Chunk %d has %d results for %d sources", i, v.Len(), c.size)
+        }
+
+        if !joined.IsValid() {
+            joined = reflect.MakeSlice(v.Type(), 0, size)
+        }
+
+        if v.Type() != joined.Type() {
+            return nil, ErrChunkResult.Newf("Chunk %d result is %s, not %s", i, v.Type(), joined.Type())
+        }
+
+        joined = reflect.AppendSlice(joined, v)
+    }
+
+    if len(unprocessed) == 0 {
+        return joined.Interface(), nil
+    }
+
+    if joined.IsValid() && joined.Type() != reflect.TypeOf([]*Result{}) {
+        return &PartialBatch{
+            Results:     joined.Interface(),
+            Unprocessed: unprocessed,
+        }, nil
+    }
+
+    var processed []*Result
+    if joined.IsValid() {
+        processed = joined.Interface().([]*Result)
+    }
+
+    results := make([]*Result, 0, size)
+    for i := 0; i < size; i++ {
+        if len(unprocessed) > 0 && unprocessed[0] == i {
+            results = append(results, NewResult(nil, ErrDeadlineNear.New()))
+            unprocessed = unprocessed[1:]
+            continue
+        }
+
+        results = append(results, processed[0])
+        processed = processed[1:]
+    }
+
+    return results, nil
+}
+
+type attempt struct {
+    result    interface{}
+    err       error
+    panicked  bool
+    recovered *attemptPanic
+}
+
+// attemptPanic carries a panic out of an attempt goroutine with the stack
+// captured where it happened.
+type attemptPanic struct {
+    recovered interface{}
+    stack     []byte
+}
+
+func (h *Handle) withTimeout(ctx context.Context, call func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+    attemptCtx, cancel := context.WithTimeout(ctx, h.timeout)
+    defer cancel()
+
+    done := make(chan *attempt, 1)
+    go func() {
+        a := &attempt{panicked: true}
+        defer func() {
+            if a.panicked {
+                a.recovered = &attemptPanic{recover(), debug.Stack()}
+            }
+            done <- a
+        }()
+
+        a.result, a.err = call(attemptCtx)
+        a.panicked = false
+    }()
+
+    select {
+    case a := <-done:
+        if a.panicked {
+            panic(a.recovered)
+        }
+        if a.err != nil && a.err == attemptCtx.Err() {
+            return nil, h.attemptTimeout(ctx)
+        }
+        return a.result, a.err
+    case <-attemptCtx.Done():
+        return nil, h.attemptTimeout(ctx)
+    }
+}
+
+// attemptTimeout tells an attempt cut by its own timeout from one cut by the
+// invocation deadline, which leaves no time to retry.
+func (h *Handle) attemptTimeout(ctx context.Context) error {
+    if ctx.Err() != nil {
+        return ErrAttemptTimeout.Newf("Handler attempt stopped by the invocation: %s", ctx.Err())
+    }
+
+    return ErrAttemptTimeout.Newf("Handler attempt timed out after %s", h.timeout)
+}
+
+func preparePayload(payload, defaultPayload json.RawMessage) (json.RawMessage, error) {
+    payload = bytes.TrimSpace(bytes.TrimPrefix(payload, byteOrderMark))
+    if len(payload) > 0 && !bytes.Equal(payload, nullPayload) {
+        return payload, nil
+    }
+
+    payload = bytes.TrimSpace(defaultPayload)
+    if len(payload) == 0 {
+        return nil, ErrEmptyPayload.New()
+    }
+
+    return payload, nil
+}
+
+func (h *Handle) parseSource(ctx context.Context, payload json.RawMessage) (interface{}, bool, error) {
     firstChar := payload[0]
-    if firstChar == firstCharArray {
+    switch {
+    case firstChar == firstCharArray:
         sources := h.handle.ParseSources(ctx, payload)
-        return sources, true
-    } else if firstChar == firstCharObject {
+        return sources, true, nil
+    case firstChar == firstCharObject:
         source := h.handle.ParseSource(ctx, payload)
-        return source, false
+        return source, false, nil
+    case isScalar(payload):
+        sh, ok := h.handle.(ScalarHandler)
+        if !ok {
+            return nil, false, ErrUnsupportedPayload.New()
+        }
+        source := sh.ParseScalar(ctx, payload)
+        return source, false, nil
+    }
+
+    return nil, false, ErrUnableParseRequest.New()
+}
+
+func isScalar(payload json.RawMessage) bool {
+    firstChar := payload[0]
+    if firstChar == firstCharString || firstChar == '-' || (firstChar >= '0' && firstChar <= '9') {
+        return true
     }
 
-    panic(errors.InternalError("UnableParseRequest", "Unable to parse request"))
+    return bytes.Equal(payload, []byte("true")) || bytes.Equal(payload, []byte("false"))
 }
 
 func (h *Handle) recovery(ctx context.Context, payload json.RawMessage, result *interface{}, err *error) {
-    if r := recover(); r != nil {
-        switch cause := r.(type) {
-        case errors.Error:
-            *err = cause.WithPanic()
-        case error:
-            *err = errors.InternalError(GetErrorType(err), cause.Error()).WithPanic()
-        default:
-            *err = errors.InternalError(GetErrorType(err), fmt.Sprintf("%v", cause)).WithPanic()
-        }
+    r := recover()
+    if r == nil {
+        return
+    }
 
-        if h.panicHandler != nil {
-            *result, *err = h.panicHandler(ctx, payload, *err)
-        }
+    info := &PanicInfo{
+        Recovered: r,
+        Payload:   payload,
+    }
+    if ap, ok := r.(*attemptPanic); ok {
+        info.Recovered = ap.recovered
+        info.Stack = ap.stack
+    } else {
+        info.Stack = debug.Stack()
+    }
+
+    if inv := InvocationFromContext(ctx); inv != nil {
+        inv.Panicked = true
+        info.Payload = inv.Payload
+        info.Source = inv.Source
+        info.IsBatch = inv.IsBatch
+        info.Attempt = inv.Attempts
+    }
+
+    info.Err = PanicError(info.Recovered)
+    for _, o := range h.observers {
+        o.OnPanic(ctx, info)
+    }
+
+    *err = info.Err
+    if h.panicHandler != nil {
+        *result, *err = h.panicHandler(ctx, info)
     }
 }
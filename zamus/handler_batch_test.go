@@ -3,7 +3,10 @@ package zamus
 import (
     "context"
     "encoding/json"
+    "sync"
+    "sync/atomic"
     "testing"
+    "time"
 
     "github.com/onedaycat/errors"
     "github.com/stretchr/testify/require"
@@ -73,7 +76,7 @@ func TestBatchHandlerPanicWithPanicHandler(t *testing.T) {
     })
 
     t.Run("With Response", func(t *testing.T) {
-        h.OnPanicHandler(func(ctx context.Context, payload json.RawMessage, err error) (interface{}, error) {
+        h.OnPanicHandler(func(ctx context.Context, info *PanicInfo) (interface{}, error) {
             return &testRes{Name: "error"}, nil
         })
 
@@ -85,7 +88,7 @@ func TestBatchHandlerPanicWithPanicHandler(t *testing.T) {
     })
 
     t.Run("With Custom error", func(t *testing.T) {
-        h.OnPanicHandler(func(ctx context.Context, payload json.RawMessage, err error) (interface{}, error) {
+        h.OnPanicHandler(func(ctx context.Context, info *PanicInfo) (interface{}, error) {
             return nil, errors.InternalError("notfound", "msg1")
         })
 
@@ -298,3 +301,227 @@ func TestBatchHandlerRetryHandler(t *testing.T) {
         require.Equal(t, 2, called)
     })
 }
+
+func TestBatchHandlerTimeout(t *testing.T) {
+    var called int32
+    h := New(&testHandler{
+        batchHandler: func(ctx context.Context, sources interface{}) (interface{}, error) {
+            if atomic.AddInt32(&called, 1) == 1 {
+                <-ctx.Done()
+                return nil, ctx.Err()
+            }
+
+            return []*testRes{{Name: "1"}, {Name: "2"}}, nil
+        },
+    })
+    h.SetTimeout(10 * time.Millisecond)
+    h.SetRetry(1)
+
+    ctx := context.Background()
+    result, err := h.Invoke(ctx, []byte(`[{"id":"1"},{"id":"2"}]`))
+
+    require.NoError(t, err)
+    require.Equal(t, []*testRes{{Name: "1"}, {Name: "2"}}, result)
+    require.Equal(t, int32(2), atomic.LoadInt32(&called))
+}
+
+func TestBatchHandlerChunk(t *testing.T) {
+    var mu sync.Mutex
+    var calls [][]string
+    failed := false
+    th := &testHandler{
+        batchHandler: func(ctx context.Context, sources interface{}) (interface{}, error) {
+            src := sources.([]*testReq)
+            ids := make([]string, 0, len(src))
+            res := make([]*testRes, 0, len(src))
+            for _, s := range src {
+                ids = append(ids, s.ID)
+                res = append(res, &testRes{Name: s.ID})
+            }
+
+            mu.Lock()
+            defer mu.Unlock()
+            calls = append(calls, ids)
+            if src[0].ID == "3" && !failed {
+                failed = true
+                return nil, errors.InternalError("code1", "msg1")
+            }
+
+            return res, nil
+        },
+    }
+    var inv *Invocation
+    h := New(th)
+    h.SetRetry(1)
+    h.Use(func(next InvokeHandler) InvokeHandler {
+        return func(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+            inv = InvocationFromContext(ctx)
+            return next(ctx, payload)
+        }
+    })
+    payload := []byte(`[{"id":"1"},{"id":"2"},{"id":"3"},{"id":"4"},{"id":"5"}]`)
+    expected := []*testRes{{Name: "1"}, {Name: "2"}, {Name: "3"}, {Name: "4"}, {Name: "5"}}
+
+    for _, concurrency := range []int{1, 3} {
+        calls = nil
+        failed = false
+        h.SetBatchChunk(2, concurrency)
+
+        result, err := h.Invoke(context.Background(), payload)
+
+        require.NoError(t, err)
+        require.Equal(t, expected, result)
+        require.Len(t, calls, 4)
+        require.ElementsMatch(t, [][]string{{"1", "2"}, {"3", "4"}, {"3", "4"}, {"5"}}, calls)
+        require.Equal(t, 2, inv.Attempts)
+    }
+
+    t.Run("Chunk fails after retries", func(t *testing.T) {
+        h.SetBatchChunk(2, 1)
+        th.batchHandler = func(ctx context.Context, sources interface{}) (interface{}, error) {
+            if sources.([]*testReq)[0].ID == "3" {
+                return nil, errors.InternalError("code1", "msg1")
+            }
+            return []*testRes{}, nil
+        }
+
+        result, err := h.Invoke(context.Background(), payload)
+
+        require.Equal(t, "code1: msg1", err.Error())
+        require.Nil(t, result)
+    })
+
+    t.Run("Result is not a slice", func(t *testing.T) {
+        th.batchHandler = func(ctx context.Context, sources interface{}) (interface{}, error) {
+            return &testRes{Name: "1"}, nil
+        }
+
+        _, err := h.Invoke(context.Background(), payload)
+
+        require.True(t, ErrChunkResult.Is(err.(errors.Error)))
+    })
+
+    t.Run("Result is nil", func(t *testing.T) {
+        th.batchHandler = func(ctx context.Context, sources interface{}) (interface{}, error) {
+            if sources.([]*testReq)[0].ID == "3" {
+                return nil, nil
+            }
+            return []*testRes{{Name: "1"}, {Name: "2"}}[:len(sources.([]*testReq))], nil
+        }
+
+        result, err := h.Invoke(context.Background(), payload)
+
+        require.True(t, ErrChunkResult.Is(err.(errors.Error)))
+        require.Equal(t, "ChunkResult: Chunk 1 result is nil", err.Error())
+        require.Nil(t, result)
+    })
+
+    t.Run("Result is short", func(t *testing.T) {
+        th.batchHandler = func(ctx context.Context, sources interface{}) (interface{}, error) {
+            return []*testRes{{Name: "1"}}, nil
+        }
+
+        result, err := h.Invoke(context.Background(), payload)
+
+        require.True(t, ErrChunkResult.Is(err.(errors.Error)))
+        require.Equal(t, "ChunkResult: Chunk 0 has 1 results for 2 sources", err.Error())
+        require.Nil(t, result)
+    })
+
+    t.Run("Panic in concurrent chunk", func(t *testing.T) {
+        h.SetBatchChunk(2, 3)
+        th.batchHandler = func(ctx context.Context, sources interface{}) (interface{}, error) {
+            panic(errors.InternalError("code2", "msg2"))
+        }
+
+        _, err := h.Invoke(context.Background(), payload)
+
+        require.Equal(t, "code2: msg2", err.Error())
+        require.True(t, err.(errors.Error).IsPanic())
+    })
+}
+
+func TestBatchHandlerDeadline(t *testing.T) {
+    var called int32
+    th := &testHandler{
+        batchHandler: func(ctx context.Context, sources interface{}) (interface{}, error) {
+            atomic.AddInt32(&called, 1)
+            time.Sleep(30 * time.Millisecond)
+            src := sources.([]*testReq)
+            res := make([]*Result, 0, len(src))
+            for _, s := range src {
+                res = append(res, NewResult(s.ID, nil))
+            }
+
+            return res, nil
+        },
+    }
+    h := New(th)
+    h.SetBatchChunk(2, 1)
+    h.SetDeadlineThreshold(time.Second - 20*time.Millisecond)
+    payload := []byte(`[{"id":"1"},{"id":"2"},{"id":"3"},{"id":"4"},{"id":"5"}]`)
+    unstarted := &Result{Error: NewResultError(ErrDeadlineNear.New())}
+
+    t.Run("Chunks not started", func(t *testing.T) {
+        ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+        defer cancel()
+
+        result, err := h.Invoke(ctx, payload)
+
+        require.NoError(t, err)
+        require.Equal(t, []*Result{{Data: "1"}, {Data: "2"}, unstarted, unstarted, unstarted}, result)
+        require.Equal(t, int32(1), atomic.LoadInt32(&called))
+    })
+
+    t.Run("Result is not []*Result", func(t *testing.T) {
+        atomic.StoreInt32(&called, 0)
+        th.batchHandler = func(ctx context.Context, sources interface{}) (interface{}, error) {
+            atomic.AddInt32(&called, 1)
+            time.Sleep(30 * time.Millisecond)
+            return []*testRes{{Name: "1"}, {Name: "2"}}, nil
+        }
+        ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+        defer cancel()
+
+        result, err := h.Invoke(ctx, payload)
+
+        require.NoError(t, err)
+        require.Equal(t, &PartialBatch{
+            Results:     []*testRes{{Name: "1"}, {Name: "2"}},
+            Unprocessed: []int{2, 3, 4},
+        }, result)
+        require.Equal(t, int32(1), atomic.LoadInt32(&called))
+    })
+
+    t.Run("Nothing started", func(t *testing.T) {
+        atomic.StoreInt32(&called, 0)
+        h.SetDeadlineThreshold(2 * time.Second)
+        defer h.SetDeadlineThreshold(time.Second - 20*time.Millisecond)
+        ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+        defer cancel()
+
+        result, err := h.Invoke(ctx, payload)
+
+        require.NoError(t, err)
+        require.Equal(t, []*Result{unstarted, unstarted, unstarted, unstarted, unstarted}, result)
+        require.Equal(t, int32(0), atomic.LoadInt32(&called))
+    })
+
+    t.Run("No retry", func(t *testing.T) {
+        atomic.StoreInt32(&called, 0)
+        th.batchHandler = func(ctx context.Context, sources interface{}) (interface{}, error) {
+            atomic.AddInt32(&called, 1)
+            time.Sleep(30 * time.Millisecond)
+            return nil, errors.InternalError("code1", "msg1")
+        }
+        h.SetBatchChunk(0, 0)
+        h.SetRetry(3)
+        ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+        defer cancel()
+
+        _, err := h.Invoke(ctx, payload)
+
+        require.Equal(t, "code1: msg1", err.Error())
+        require.Equal(t, int32(1), atomic.LoadInt32(&called))
+    })
+}
@@ -3,7 +3,10 @@ package zamus
 import (
     "context"
     "encoding/json"
+    "fmt"
+    "sync/atomic"
     "testing"
+    "time"
 
     "github.com/onedaycat/errors"
     "github.com/stretchr/testify/require"
@@ -133,7 +136,7 @@ func TestHandlerPanic(t *testing.T) {
         result, err := h.Invoke(ctx, []byte(`{"id":"1"}`))
 
         require.NotNil(t, err)
-        require.Equal(t, "error: msg1", err.Error())
+        require.Equal(t, "string: msg1", err.Error())
         require.Nil(t, result)
     })
 }
@@ -147,7 +150,7 @@ func TestHandlerPanicWithPanicHandler(t *testing.T) {
     })
 
     t.Run("With Response", func(t *testing.T) {
-        h.OnPanicHandler(func(ctx context.Context, payload json.RawMessage, err error) (interface{}, error) {
+        h.OnPanicHandler(func(ctx context.Context, info *PanicInfo) (interface{}, error) {
             return &testRes{Name: "error"}, nil
         })
 
@@ -159,7 +162,7 @@ func TestHandlerPanicWithPanicHandler(t *testing.T) {
     })
 
     t.Run("With Custom error", func(t *testing.T) {
-        h.OnPanicHandler(func(ctx context.Context, payload json.RawMessage, err error) (interface{}, error) {
+        h.OnPanicHandler(func(ctx context.Context, info *PanicInfo) (interface{}, error) {
             return nil, errors.InternalError("notfound", "msg1")
         })
 
@@ -172,6 +175,44 @@ func TestHandlerPanicWithPanicHandler(t *testing.T) {
     })
 }
 
+func TestHandlerPanicInfo(t *testing.T) {
+    var info *PanicInfo
+    h := New(&testHandler{
+        handler: func(ctx context.Context, source interface{}) (interface{}, error) {
+            panic(fmt.Errorf("msg1"))
+        },
+    })
+    h.OnPanicHandler(func(ctx context.Context, pi *PanicInfo) (interface{}, error) {
+        info = pi
+        return nil, pi.Err
+    })
+
+    t.Run("Handler", func(t *testing.T) {
+        ctx := context.Background()
+        result, err := h.Invoke(ctx, []byte(`{"id":"1"}`))
+
+        require.Nil(t, result)
+        require.Equal(t, "errorString: msg1", err.Error())
+        require.Equal(t, fmt.Errorf("msg1"), info.Recovered)
+        require.True(t, info.Err.IsPanic())
+        require.Equal(t, &testReq{ID: "1"}, info.Source)
+        require.False(t, info.IsBatch)
+        require.Equal(t, 1, info.Attempt)
+        require.Contains(t, string(info.Stack), "TestHandlerPanicInfo")
+    })
+
+    t.Run("Handler with timeout", func(t *testing.T) {
+        h.SetTimeout(time.Second)
+        defer h.SetTimeout(0)
+
+        ctx := context.Background()
+        _, err := h.Invoke(ctx, []byte(`{"id":"1"}`))
+
+        require.Equal(t, "errorString: msg1", err.Error())
+        require.Contains(t, string(info.Stack), "TestHandlerPanicInfo")
+    })
+}
+
 func TestHandlerPreHandler(t *testing.T) {
     h := New(&testHandler{
         handler: func(ctx context.Context, source interface{}) (interface{}, error) {
@@ -347,3 +388,448 @@ func TestHandlerRetryHandler(t *testing.T) {
         require.Equal(t, 2, called)
     })
 }
+
+func TestHandlerMiddleware(t *testing.T) {
+    h := New(&testHandler{
+        handler: func(ctx context.Context, source interface{}) (interface{}, error) {
+            panic(errors.InternalError("code1", "msg1"))
+        },
+    })
+
+    var order []string
+    h.Use(func(next InvokeHandler) InvokeHandler {
+        return func(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+            order = append(order, "first")
+            return next(ctx, payload)
+        }
+    }, func(next InvokeHandler) InvokeHandler {
+        return func(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+            order = append(order, "second")
+            _, err := next(ctx, payload)
+            require.True(t, err.(errors.Error).IsPanic())
+
+            return &testRes{Name: "recovered"}, nil
+        }
+    })
+
+    ctx := context.Background()
+    result, err := h.Invoke(ctx, []byte(`{"id":"1"}`))
+
+    require.NoError(t, err)
+    require.Equal(t, &testRes{Name: "recovered"}, result)
+    require.Equal(t, []string{"first", "second"}, order)
+}
+
+func TestHandlerTimeout(t *testing.T) {
+    var called int32
+    th := &testHandler{
+        handler: func(ctx context.Context, source interface{}) (interface{}, error) {
+            if atomic.AddInt32(&called, 1) == 1 {
+                <-ctx.Done()
+                return nil, ctx.Err()
+            }
+
+            return &testRes{Name: "2"}, nil
+        },
+    }
+    h := New(th)
+    h.SetTimeout(10 * time.Millisecond)
+
+    t.Run("Retry after timeout", func(t *testing.T) {
+        atomic.StoreInt32(&called, 0)
+        h.SetRetry(1)
+
+        ctx := context.Background()
+        result, err := h.Invoke(ctx, []byte(`{"id":"1"}`))
+
+        require.NoError(t, err)
+        require.Equal(t, &testRes{Name: "2"}, result)
+        require.Equal(t, int32(2), atomic.LoadInt32(&called))
+    })
+
+    t.Run("Timeout without retry", func(t *testing.T) {
+        atomic.StoreInt32(&called, 0)
+        h.SetRetry(0)
+
+        ctx := context.Background()
+        result, err := h.Invoke(ctx, []byte(`{"id":"1"}`))
+
+        require.Implements(t, (*errors.Error)(nil), err)
+        require.True(t, ErrAttemptTimeout.Is(err.(errors.Error)))
+        require.Equal(t, "AttemptTimeout: Handler attempt timed out after 10ms", err.Error())
+        require.Nil(t, result)
+        require.Equal(t, int32(1), atomic.LoadInt32(&called))
+    })
+
+    t.Run("No retry past deadline", func(t *testing.T) {
+        atomic.StoreInt32(&called, 0)
+        h.SetRetry(3)
+
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+        defer cancel()
+        result, err := h.Invoke(ctx, []byte(`{"id":"1"}`))
+
+        require.Implements(t, (*errors.Error)(nil), err)
+        require.True(t, ErrAttemptTimeout.Is(err.(errors.Error)))
+        require.Equal(t, "AttemptTimeout: Handler attempt stopped by the invocation: context deadline exceeded", err.Error())
+        require.Nil(t, result)
+        require.Equal(t, int32(1), atomic.LoadInt32(&called))
+    })
+
+    t.Run("Panic in attempt", func(t *testing.T) {
+        h.SetRetry(0)
+        th.handler = func(ctx context.Context, source interface{}) (interface{}, error) {
+            panic(errors.InternalError("code1", "msg1"))
+        }
+
+        ctx := context.Background()
+        result, err := h.Invoke(ctx, []byte(`{"id":"1"}`))
+
+        require.Equal(t, "code1: msg1", err.Error())
+        require.True(t, err.(errors.Error).IsPanic())
+        require.Nil(t, result)
+    })
+}
+
+type testScalarHandler struct {
+    testHandler
+}
+
+func (h *testScalarHandler) ParseScalar(ctx context.Context, payload json.RawMessage) interface{} {
+    var source interface{}
+    err := jsonen.Unmarshal(payload, &source)
+    if err != nil {
+        panic(err)
+    }
+
+    return source
+}
+
+func TestHandlerPayload(t *testing.T) {
+    echo := func(ctx context.Context, source interface{}) (interface{}, error) {
+        return source, nil
+    }
+    h := New(&testHandler{handler: echo})
+
+    t.Run("Leading whitespace and BOM", func(t *testing.T) {
+        ctx := context.Background()
+        result, err := h.Invoke(ctx, []byte("\xEF\xBB\xBF \n\t{\"id\":\"1\"} "))
+
+        require.NoError(t, err)
+        require.Equal(t, &testReq{ID: "1"}, result)
+    })
+
+    t.Run("Empty", func(t *testing.T) {
+        ctx := context.Background()
+        result, err := h.Invoke(ctx, []byte(""))
+
+        require.True(t, ErrEmptyPayload.Is(err.(errors.Error)))
+        require.False(t, err.(errors.Error).IsPanic())
+        require.Nil(t, result)
+    })
+
+    t.Run("Null", func(t *testing.T) {
+        ctx := context.Background()
+        result, err := h.Invoke(ctx, []byte(" null"))
+
+        require.True(t, ErrEmptyPayload.Is(err.(errors.Error)))
+        require.Nil(t, result)
+    })
+
+    t.Run("Unsupported scalar", func(t *testing.T) {
+        ctx := context.Background()
+        result, err := h.Invoke(ctx, []byte(`"abc"`))
+
+        require.True(t, ErrUnsupportedPayload.Is(err.(errors.Error)))
+        require.Nil(t, result)
+    })
+
+    t.Run("Invalid", func(t *testing.T) {
+        ctx := context.Background()
+        result, err := h.Invoke(ctx, []byte(`abc`))
+
+        require.True(t, ErrUnableParseRequest.Is(err.(errors.Error)))
+        require.Nil(t, result)
+    })
+
+    t.Run("Default payload", func(t *testing.T) {
+        h.SetDefaultPayload([]byte(`{"id":"default"}`))
+        defer h.SetDefaultPayload(nil)
+
+        ctx := context.Background()
+        result, err := h.Invoke(ctx, []byte("null"))
+
+        require.NoError(t, err)
+        require.Equal(t, &testReq{ID: "default"}, result)
+
+        result, err = h.Invoke(ctx, nil)
+
+        require.NoError(t, err)
+        require.Equal(t, &testReq{ID: "default"}, result)
+    })
+}
+
+func TestHandlerScalarPayload(t *testing.T) {
+    h := New(&testScalarHandler{testHandler{
+        handler: func(ctx context.Context, source interface{}) (interface{}, error) {
+            return source, nil
+        },
+    }})
+
+    tests := []struct {
+        payload string
+        result  interface{}
+    }{
+        {`"abc"`, "abc"},
+        {`42`, float64(42)},
+        {`-1.5`, -1.5},
+        {`true`, true},
+        {`false`, false},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.payload, func(t *testing.T) {
+            ctx := context.Background()
+            result, err := h.Invoke(ctx, []byte(tt.payload))
+
+            require.NoError(t, err)
+            require.Equal(t, tt.result, result)
+        })
+    }
+}
+
+func TestHandlerInvocation(t *testing.T) {
+    var inv *Invocation
+    h := New(&testHandler{
+        handler: func(ctx context.Context, source interface{}) (interface{}, error) {
+            inv = InvocationFromContext(ctx)
+            return nil, errors.InternalError("code1", "msg1")
+        },
+    })
+    h.SetRetry(2)
+
+    ctx := context.Background()
+    _, err := h.Invoke(ctx, []byte(` {"id":"1"}`))
+
+    require.NotNil(t, err)
+    require.Equal(t, &Invocation{
+        Payload:  []byte(`{"id":"1"}`),
+        Source:   &testReq{ID: "1"},
+        IsBatch:  false,
+        Attempts: 3,
+        attrs:    inv.attrs,
+    }, inv)
+    require.Nil(t, InvocationFromContext(ctx))
+}
+
+func TestHandlerAttrs(t *testing.T) {
+    var seen []string
+    h := New(&testHandler{
+        handler: func(ctx context.Context, source interface{}) (interface{}, error) {
+            attrs := Attrs(ctx)
+            seen = append(seen, "handler "+attrs.String("principal"))
+            attrs.Set("attempts", attrs.Int("attempts")+1)
+            return nil, errors.InternalError("code1", "msg1")
+        },
+    })
+    h.SetRetry(1)
+    h.RegisterPreHandler(func(ctx context.Context, payload json.RawMessage, src interface{}) (interface{}, error) {
+        Attrs(ctx).Set("principal", "user1")
+        return nil, nil
+    })
+    h.OnRetryFailedHandler(func(ctx context.Context, payload json.RawMessage, src interface{}, err error) (interface{}, error) {
+        seen = append(seen, fmt.Sprintf("retry failed %d", Attrs(ctx).Int("attempts")))
+        return nil, err
+    })
+    h.RegisterPostHandler(func(ctx context.Context, payload json.RawMessage, src interface{}, res interface{}, err error) (interface{}, error) {
+        seen = append(seen, "post "+Attrs(ctx).String("principal"))
+        return res, err
+    })
+
+    _, err := h.Invoke(context.Background(), []byte(`{"id":"1"}`))
+    require.Error(t, err)
+    require.Equal(t, []string{"handler user1", "handler user1", "retry failed 2", "post user1"}, seen)
+
+    t.Run("Typed getters", func(t *testing.T) {
+        attrs := newAttributes()
+        now := time.Now()
+        attrs.Set("s", "a")
+        attrs.Set("f", 1.5)
+        attrs.Set("b", true)
+        attrs.Set("t", now)
+
+        require.Equal(t, "a", attrs.String("s"))
+        require.Equal(t, "", attrs.String("f"))
+        require.Equal(t, 1, attrs.Int("f"))
+        require.Equal(t, 1.5, attrs.Float64("f"))
+        require.True(t, attrs.Bool("b"))
+        require.Equal(t, now, attrs.Time("t"))
+        require.Len(t, attrs.All(), 4)
+
+        attrs.Delete("s")
+        _, ok := attrs.Get("s")
+        require.False(t, ok)
+    })
+
+    t.Run("Outside invoke", func(t *testing.T) {
+        ctx := context.Background()
+        Attrs(ctx).Set("a", "1")
+
+        require.Equal(t, "", Attrs(ctx).String("a"))
+    })
+}
+
+func errString(err error) string {
+    if err == nil {
+        return "<nil>"
+    }
+
+    return err.Error()
+}
+
+type testObserver struct {
+    NopObserver
+    events []string
+}
+
+func (o *testObserver) OnParseStart(ctx context.Context, payload json.RawMessage) {
+    o.events = append(o.events, "parse start")
+}
+
+func (o *testObserver) OnParseEnd(ctx context.Context, event *ParseEvent) {
+    o.events = append(o.events, fmt.Sprintf("parse end %s", errString(event.Err)))
+}
+
+func (o *testObserver) OnShortCircuit(ctx context.Context, event *ShortCircuitEvent) {
+    o.events = append(o.events, fmt.Sprintf("short circuit %s", errString(event.Err)))
+}
+
+func (o *testObserver) OnAttemptStart(ctx context.Context, event *AttemptEvent) {
+    o.events = append(o.events, fmt.Sprintf("attempt %d start", event.Attempt))
+}
+
+func (o *testObserver) OnAttemptEnd(ctx context.Context, event *AttemptEvent) {
+    o.events = append(o.events, fmt.Sprintf("attempt %d end %s", event.Attempt, errString(event.Err)))
+}
+
+func (o *testObserver) OnRetry(ctx context.Context, event *RetryEvent) {
+    o.events = append(o.events, fmt.Sprintf("retry after %d", event.Attempt))
+}
+
+func (o *testObserver) OnRetryExhausted(ctx context.Context, event *RetryEvent) {
+    o.events = append(o.events, fmt.Sprintf("retry exhausted after %d", event.Attempt))
+}
+
+func (o *testObserver) OnPanic(ctx context.Context, info *PanicInfo) {
+    o.events = append(o.events, fmt.Sprintf("panic %v", info.Recovered))
+}
+
+func (o *testObserver) OnComplete(ctx context.Context, event *CompleteEvent) {
+    o.events = append(o.events, fmt.Sprintf("complete %d %s", event.Attempts, errString(event.Err)))
+}
+
+func TestHandlerObserver(t *testing.T) {
+    called := 0
+    th := &testHandler{
+        handler: func(ctx context.Context, source interface{}) (interface{}, error) {
+            called++
+            if called == 1 {
+                return nil, errors.InternalError("code1", "msg1")
+            }
+            return &testRes{Name: "1"}, nil
+        },
+    }
+    o := &testObserver{}
+    h := New(th)
+    h.SetRetry(1)
+    h.RegisterObserver(o)
+
+    t.Run("Retry and pass", func(t *testing.T) {
+        _, err := h.Invoke(context.Background(), []byte(`{"id":"1"}`))
+
+        require.NoError(t, err)
+        require.Equal(t, []string{
+            "parse start",
+            "parse end <nil>",
+            "attempt 1 start",
+            "attempt 1 end code1: msg1",
+            "retry after 1",
+            "attempt 2 start",
+            "attempt 2 end <nil>",
+            "complete 2 <nil>",
+        }, o.events)
+    })
+
+    t.Run("Retry exhausted", func(t *testing.T) {
+        o.events = nil
+        th.handler = func(ctx context.Context, source interface{}) (interface{}, error) {
+            return nil, errors.InternalError("code1", "msg1")
+        }
+
+        _, err := h.Invoke(context.Background(), []byte(`{"id":"1"}`))
+
+        require.Error(t, err)
+        require.Equal(t, []string{
+            "parse start",
+            "parse end <nil>",
+            "attempt 1 start",
+            "attempt 1 end code1: msg1",
+            "retry after 1",
+            "attempt 2 start",
+            "attempt 2 end code1: msg1",
+            "retry exhausted after 2",
+            "complete 2 code1: msg1",
+        }, o.events)
+    })
+
+    t.Run("Short circuit", func(t *testing.T) {
+        o.events = nil
+        h.RegisterPreHandler(func(ctx context.Context, payload json.RawMessage, src interface{}) (interface{}, error) {
+            return nil, errors.Unauthorized("code2", "msg2")
+        })
+        defer func() { h.preHandlers = nil }()
+
+        _, _ = h.Invoke(context.Background(), []byte(`{"id":"1"}`))
+
+        require.Equal(t, []string{
+            "parse start",
+            "parse end <nil>",
+            "short circuit code2: msg2",
+            "complete 0 code2: msg2",
+        }, o.events)
+    })
+
+    t.Run("Parse error and panic", func(t *testing.T) {
+        o.events = nil
+        th.handler = func(ctx context.Context, source interface{}) (interface{}, error) {
+            panic("boom")
+        }
+
+        _, _ = h.Invoke(context.Background(), []byte(`null`))
+        _, _ = h.Invoke(context.Background(), []byte(`{"id":"1"}`))
+
+        require.Equal(t, []string{
+            "parse start",
+            "parse end EmptyPayload: Payload is empty or null",
+            "complete 0 EmptyPayload: Payload is empty or null",
+            "parse start",
+            "parse end <nil>",
+            "attempt 1 start",
+            "panic boom",
+            "complete 1 string: boom",
+        }, o.events)
+    })
+
+    t.Run("ParseSource panics", func(t *testing.T) {
+        o.events = nil
+
+        _, err := h.Invoke(context.Background(), []byte(`{"id":1}`))
+
+        require.Error(t, err)
+        require.Len(t, o.events, 4)
+        require.Equal(t, "parse start", o.events[0])
+        require.Equal(t, "parse end "+errString(err), o.events[1])
+        require.Contains(t, o.events[2], "panic ")
+        require.Equal(t, "complete 0 "+errString(err), o.events[3])
+    })
+}
@@ -0,0 +1,81 @@
+package zamus
+
+import (
+    "context"
+    "encoding/json"
+    "time"
+
+    "github.com/onedaycat/errors"
+)
+
+type invocationKey struct{}
+
+// Invocation describes the progress of one call to Invoke. It is stored on
+// the context passed to every middleware and handler.
+type Invocation struct {
+    Payload  json.RawMessage
+    Source   interface{}
+    IsBatch  bool
+    Attempts int
+    Panicked bool
+    attrs    *Attributes
+    stopAt   time.Duration
+    // defaultPayload is the one of Handle.SetDefaultPayload, for
+    // PreparePayload.
+    defaultPayload json.RawMessage
+}
+
+// PanicInfo is passed to the PanicHandler. Source is nil when the panic
+// happened before the payload was parsed, and Attempt is zero when it
+// happened before Handler or BatchHandler was called.
+type PanicInfo struct {
+    Recovered interface{}
+    Err       errors.Error
+    Payload   json.RawMessage
+    Source    interface{}
+    IsBatch   bool
+    Attempt   int
+    Stack     []byte
+}
+
+// InvocationFromContext returns nil outside of Invoke or Run.
+func InvocationFromContext(ctx context.Context) *Invocation {
+    inv, _ := ctx.Value(invocationKey{}).(*Invocation)
+    return inv
+}
+
+// NearDeadline reports whether the time left before the deadline of ctx is
+// under the threshold set with Handle.SetDeadlineThreshold. Batch handlers
+// call it before starting each item and report the items left as failures.
+func NearDeadline(ctx context.Context) bool {
+    inv := InvocationFromContext(ctx)
+    if inv == nil || inv.stopAt <= 0 {
+        return false
+    }
+
+    deadline, ok := ctx.Deadline()
+
+    return ok && time.Until(deadline) < inv.stopAt
+}
+
+// PreparePayload returns the payload as Handle parses it: without byte order
+// mark and surrounding whitespace, and replaced by the payload of
+// Handle.SetDefaultPayload when empty or null. Middlewares looking at the
+// payload before Handle call it first.
+func PreparePayload(ctx context.Context, payload json.RawMessage) (json.RawMessage, error) {
+    var defaultPayload json.RawMessage
+    if inv := InvocationFromContext(ctx); inv != nil {
+        defaultPayload = inv.defaultPayload
+    }
+
+    return preparePayload(payload, defaultPayload)
+}
+
+func withInvocation(ctx context.Context, payload json.RawMessage) (context.Context, *Invocation) {
+    inv := &Invocation{
+        Payload: payload,
+        attrs:   newAttributes(),
+    }
+
+    return context.WithValue(ctx, invocationKey{}, inv), inv
+}
@@ -0,0 +1,130 @@
+package zamus
+
+import (
+    "context"
+    "encoding/json"
+    "time"
+)
+
+// Observer is notified of every step of an invocation. Batch chunks run
+// concurrently, so observers must be safe for concurrent use. Embed
+// NopObserver to implement only some of the callbacks.
+type Observer interface {
+    OnParseStart(ctx context.Context, payload json.RawMessage)
+    OnParseEnd(ctx context.Context, event *ParseEvent)
+    OnShortCircuit(ctx context.Context, event *ShortCircuitEvent)
+    OnAttemptStart(ctx context.Context, event *AttemptEvent)
+    OnAttemptEnd(ctx context.Context, event *AttemptEvent)
+    OnRetry(ctx context.Context, event *RetryEvent)
+    OnRetryExhausted(ctx context.Context, event *RetryEvent)
+    OnPanic(ctx context.Context, info *PanicInfo)
+    OnComplete(ctx context.Context, event *CompleteEvent)
+}
+
+// ParseEvent is sent once the payload is parsed, or failed to parse.
+type ParseEvent struct {
+    Source   interface{}
+    IsBatch  bool
+    Duration time.Duration
+    Err      error
+}
+
+// ShortCircuitEvent is sent when a pre-handler answers instead of the
+// handler.
+type ShortCircuitEvent struct {
+    Result  interface{}
+    Err     error
+    IsBatch bool
+}
+
+// AttemptEvent is sent before and after each call to Handler or
+// BatchHandler. Duration and Err are only set once the attempt ended.
+type AttemptEvent struct {
+    Attempt  int
+    IsBatch  bool
+    Duration time.Duration
+    Err      error
+}
+
+// RetryEvent is sent when a failed attempt is retried, and when no retry is
+// left. Attempt is the number of the failed attempt.
+type RetryEvent struct {
+    Attempt int
+    IsBatch bool
+    Err     error
+}
+
+// CompleteEvent is sent when Invoke returns, after every middleware.
+type CompleteEvent struct {
+    Result   interface{}
+    Err      error
+    Attempts int
+    Duration time.Duration
+}
+
+type NopObserver struct{}
+
+func (NopObserver) OnParseStart(ctx context.Context, payload json.RawMessage) {}
+func (NopObserver) OnParseEnd(ctx context.Context, event *ParseEvent) {}
+func (NopObserver) OnShortCircuit(ctx context.Context, event *ShortCircuitEvent) {}
+func (NopObserver) OnAttemptStart(ctx context.Context, event *AttemptEvent) {}
+func (NopObserver) OnAttemptEnd(ctx context.Context, event *AttemptEvent) {}
+func (NopObserver) OnRetry(ctx context.Context, event *RetryEvent) {}
+func (NopObserver) OnRetryExhausted(ctx context.Context, event *RetryEvent) {}
+func (NopObserver) OnPanic(ctx context.Context, info *PanicInfo) {}
+func (NopObserver) OnComplete(ctx context.Context, event *CompleteEvent) {}
+
+func (h *Handle) RegisterObserver(observers ...Observer) {
+    h.observers = append(h.observers, observers...)
+}
+
+func (h *Handle) observeAttempt(ctx context.Context, attempt int, isBatch bool, call func() (interface{}, error)) (interface{}, error) {
+    if len(h.observers) == 0 {
+        return call()
+    }
+
+    for _, o := range h.observers {
+        o.OnAttemptStart(ctx, &AttemptEvent{Attempt: attempt, IsBatch: isBatch})
+    }
+
+    start := time.Now()
+    result, err := call()
+    event := &AttemptEvent{
+        Attempt:  attempt,
+        IsBatch:  isBatch,
+        Duration: time.Since(start),
+        Err:      err,
+    }
+
+    for _, o := range h.observers {
+        o.OnAttemptEnd(ctx, event)
+    }
+
+    return result, err
+}
+
+func (h *Handle) observeRetry(ctx context.Context, attempt int, isBatch bool, err error, retried bool) {
+    if !retried && h.retries.times == 0 {
+        return
+    }
+
+    event := &RetryEvent{
+        Attempt: attempt,
+        IsBatch: isBatch,
+        Err:     err,
+    }
+
+    for _, o := range h.observers {
+        if retried {
+            o.OnRetry(ctx, event)
+        } else {
+            o.OnRetryExhausted(ctx, event)
+        }
+    }
+}
+
+func (h *Handle) observeShortCircuit(ctx context.Context, result interface{}, err error, isBatch bool) {
+    for _, o := range h.observers {
+        o.OnShortCircuit(ctx, &ShortCircuitEvent{Result: result, Err: err, IsBatch: isBatch})
+    }
+}
@@ -0,0 +1,135 @@
+package ratelimit
+
+import (
+    "context"
+    "fmt"
+    "strconv"
+    "sync"
+    "time"
+)
+
+// Store keeps counters shared by every execution environment, e.g. Redis
+// INCRBY with EXPIRE or a DynamoDB atomic counter with a TTL attribute.
+type Store interface {
+    Incr(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error)
+    Get(ctx context.Context, key string) (int64, error)
+}
+
+type distributed struct {
+    store  Store
+    key    string
+    limit  int64
+    window time.Duration
+    now    func() time.Time
+}
+
+// NewDistributed allows at most limit tokens per window across every
+// execution environment sharing the store. It approximates a sliding window
+// by weighting the previous fixed window with the part still overlapping.
+func NewDistributed(store Store, key string, limit int, window time.Duration) Limiter {
+    if window <= 0 {
+        panic(fmt.Sprintf("ratelimit: window must be positive, got %s", window))
+    }
+
+    return &distributed{
+        store:  store,
+        key:    key,
+        limit:  int64(limit),
+        window: window,
+        now:    time.Now,
+    }
+}
+
+func (d *distributed) Take(ctx context.Context, n int) (time.Duration, error) {
+    if int64(n) > d.limit {
+        return 0, ErrThrottled.Newf("Requested %d tokens exceeds limit %d", n, d.limit)
+    }
+
+    now := d.now()
+    current := now.Truncate(d.window)
+    elapsed := now.Sub(current)
+
+    prev, err := d.store.Get(ctx, d.windowKey(current.Add(-d.window)))
+    if err != nil {
+        return 0, err
+    }
+
+    count, err := d.store.Incr(ctx, d.windowKey(current), int64(n), 2*d.window)
+    if err != nil {
+        return 0, err
+    }
+
+    weight := float64(d.window-elapsed) / float64(d.window)
+    if float64(prev)*weight+float64(count) <= float64(d.limit) {
+        return 0, nil
+    }
+
+    if _, err = d.store.Incr(ctx, d.windowKey(current), -int64(n), 2*d.window); err != nil {
+        return 0, err
+    }
+
+    return d.window - elapsed, nil
+}
+
+func (d *distributed) windowKey(start time.Time) string {
+    return d.key + ":" + strconv.FormatInt(start.UnixNano(), 10)
+}
+
+type counter struct {
+    value  int64
+    expiry time.Time
+}
+
+type memoryStore struct {
+    mu       sync.Mutex
+    counters map[string]*counter
+    now      func() time.Time
+}
+
+// NewMemoryStore is an in-process Store, mostly useful in tests.
+func NewMemoryStore() Store {
+    return &memoryStore{
+        counters: make(map[string]*counter),
+        now:      time.Now,
+    }
+}
+
+func (s *memoryStore) Incr(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    c := s.get(key)
+    if c == nil {
+        c = &counter{expiry: s.now().Add(ttl)}
+        s.counters[key] = c
+    }
+    c.value += n
+
+    return c.value, nil
+}
+
+func (s *memoryStore) Get(ctx context.Context, key string) (int64, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    c := s.get(key)
+    if c == nil {
+        return 0, nil
+    }
+
+    return c.value, nil
+}
+
+func (s *memoryStore) get(key string) *counter {
+    c, ok := s.counters[key]
+    if !ok {
+        return nil
+    }
+
+    if !s.now().Before(c.expiry) {
+        delete(s.counters, key)
+        return nil
+    }
+
+    return c
+}
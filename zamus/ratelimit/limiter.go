@@ -0,0 +1,116 @@
+package ratelimit
+
+import (
+    "context"
+    "fmt"
+    "math"
+    "sync"
+    "time"
+)
+
+type Limiter interface {
+    // Take consumes n tokens. When the limit is reached nothing is consumed
+    // and the returned duration tells how long to wait before trying again.
+    Take(ctx context.Context, n int) (time.Duration, error)
+}
+
+type tokenBucket struct {
+    mu     sync.Mutex
+    rate   float64
+    burst  float64
+    tokens float64
+    last   time.Time
+    now    func() time.Time
+}
+
+// NewTokenBucket refills rate tokens per second up to burst tokens. The
+// bucket lives in process memory and is shared across warm invocations.
+func NewTokenBucket(rate float64, burst int) Limiter {
+    if rate <= 0 {
+        panic(fmt.Sprintf("ratelimit: rate must be positive, got %v", rate))
+    }
+
+    return &tokenBucket{
+        rate:   rate,
+        burst:  float64(burst),
+        tokens: float64(burst),
+        now:    time.Now,
+    }
+}
+
+func (b *tokenBucket) Take(ctx context.Context, n int) (time.Duration, error) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    if float64(n) > b.burst {
+        return 0, ErrThrottled.Newf("Requested %d tokens exceeds burst %d", n, int(b.burst))
+    }
+
+    now := b.now()
+    if !b.last.IsZero() {
+        b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+    }
+    b.last = now
+
+    if b.tokens >= float64(n) {
+        b.tokens -= float64(n)
+        return 0, nil
+    }
+
+    // Rounded up, a fast refill would otherwise wait 0 and look allowed
+    // without consuming anything.
+    need := float64(n) - b.tokens
+
+    return time.Duration(math.Ceil(need / b.rate * float64(time.Second))), nil
+}
+
+type slidingWindow struct {
+    mu     sync.Mutex
+    limit  int
+    window time.Duration
+    log    []time.Time
+    now    func() time.Time
+}
+
+// NewSlidingWindow allows at most limit tokens within any window. The log
+// lives in process memory and is shared across warm invocations.
+func NewSlidingWindow(limit int, window time.Duration) Limiter {
+    if window <= 0 {
+        panic(fmt.Sprintf("ratelimit: window must be positive, got %s", window))
+    }
+
+    return &slidingWindow{
+        limit:  limit,
+        window: window,
+        log:    make([]time.Time, 0, limit),
+        now:    time.Now,
+    }
+}
+
+func (w *slidingWindow) Take(ctx context.Context, n int) (time.Duration, error) {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+
+    if n > w.limit {
+        return 0, ErrThrottled.Newf("Requested %d tokens exceeds limit %d", n, w.limit)
+    }
+
+    now := w.now()
+    start := now.Add(-w.window)
+    i := 0
+    for i < len(w.log) && !w.log[i].After(start) {
+        i++
+    }
+    w.log = w.log[i:]
+
+    if len(w.log)+n <= w.limit {
+        for j := 0; j < n; j++ {
+            w.log = append(w.log, now)
+        }
+        return 0, nil
+    }
+
+    oldest := w.log[len(w.log)+n-w.limit-1]
+
+    return oldest.Add(w.window).Sub(now), nil
+}
@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+    "context"
+    "encoding/json"
+    "reflect"
+    "time"
+
+    "github.com/onedaycat/errors"
+)
+
+var ErrThrottled = errors.DefInternalError("Throttled", "Rate limit exceeded")
+
+type RateLimit struct {
+    limiter Limiter
+    wait    bool
+}
+
+func New(limiter Limiter) *RateLimit {
+    return &RateLimit{
+        limiter: limiter,
+    }
+}
+
+// SetWait delays the invocation until tokens are available instead of
+// failing with ErrThrottled, as long as the wait fits before the deadline.
+func (r *RateLimit) SetWait(wait bool) {
+    r.wait = wait
+}
+
+func (r *RateLimit) PreHandler(ctx context.Context, payload json.RawMessage, src interface{}) (interface{}, error) {
+    return nil, r.Take(ctx, 1)
+}
+
+// BatchPreHandler meters one token per record of the batch.
+func (r *RateLimit) BatchPreHandler(ctx context.Context, payload json.RawMessage, src interface{}) (interface{}, error) {
+    n := 1
+    if v := reflect.ValueOf(src); v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+        n = v.Len()
+    }
+
+    if n == 0 {
+        return nil, nil
+    }
+
+    return nil, r.Take(ctx, n)
+}
+
+func (r *RateLimit) Take(ctx context.Context, n int) error {
+    for {
+        wait, err := r.limiter.Take(ctx, n)
+        if err != nil {
+            return err
+        }
+
+        if wait <= 0 {
+            return nil
+        }
+
+        if !r.wait {
+            return ErrThrottled.Newf("Rate limit exceeded, retry after %s", wait)
+        }
+
+        if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+            return ErrThrottled.Newf("Rate limit exceeded, retry after %s is past the deadline", wait)
+        }
+
+        timer := time.NewTimer(wait)
+        select {
+        case <-ctx.Done():
+            timer.Stop()
+            return ErrThrottled.Newf("Rate limit exceeded: %s", ctx.Err())
+        case <-timer.C:
+        }
+    }
+}
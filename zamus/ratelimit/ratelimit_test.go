@@ -0,0 +1,164 @@
+package ratelimit
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/onedaycat/errors"
+    "github.com/onedaycat/zamus/zamus"
+    "github.com/onedaycat/zamus/zamus/zamustest"
+    "github.com/stretchr/testify/require"
+)
+
+type testClock struct {
+    now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+    return c.now
+}
+
+func TestTokenBucket(t *testing.T) {
+    clock := &testClock{now: time.Now()}
+    l := NewTokenBucket(2, 4).(*tokenBucket)
+    l.now = clock.Now
+    ctx := context.Background()
+
+    wait, err := l.Take(ctx, 3)
+    require.NoError(t, err)
+    require.Zero(t, wait)
+
+    wait, err = l.Take(ctx, 2)
+    require.NoError(t, err)
+    require.Equal(t, 500*time.Millisecond, wait)
+
+    clock.now = clock.now.Add(500 * time.Millisecond)
+    wait, err = l.Take(ctx, 2)
+    require.NoError(t, err)
+    require.Zero(t, wait)
+
+    _, err = l.Take(ctx, 5)
+    require.True(t, ErrThrottled.Is(err.(errors.Error)))
+
+    t.Run("Wait is never zero", func(t *testing.T) {
+        l := NewTokenBucket(1e10, 1).(*tokenBucket)
+        l.now = clock.Now
+
+        wait, err := l.Take(ctx, 1)
+        require.NoError(t, err)
+        require.Zero(t, wait)
+
+        wait, err = l.Take(ctx, 1)
+        require.NoError(t, err)
+        require.Equal(t, time.Nanosecond, wait)
+    })
+}
+
+func TestInvalidLimiter(t *testing.T) {
+    require.Panics(t, func() { NewTokenBucket(0, 1) })
+    require.Panics(t, func() { NewSlidingWindow(1, 0) })
+    require.Panics(t, func() { NewDistributed(NewMemoryStore(), "api", 1, 0) })
+}
+
+func TestSlidingWindow(t *testing.T) {
+    clock := &testClock{now: time.Now()}
+    l := NewSlidingWindow(3, time.Second).(*slidingWindow)
+    l.now = clock.Now
+    ctx := context.Background()
+
+    wait, err := l.Take(ctx, 2)
+    require.NoError(t, err)
+    require.Zero(t, wait)
+
+    clock.now = clock.now.Add(400 * time.Millisecond)
+    wait, err = l.Take(ctx, 1)
+    require.NoError(t, err)
+    require.Zero(t, wait)
+
+    wait, err = l.Take(ctx, 1)
+    require.NoError(t, err)
+    require.Equal(t, 600*time.Millisecond, wait)
+
+    clock.now = clock.now.Add(600 * time.Millisecond)
+    wait, err = l.Take(ctx, 2)
+    require.NoError(t, err)
+    require.Zero(t, wait)
+}
+
+func TestDistributed(t *testing.T) {
+    clock := &testClock{now: time.Unix(1000, 0)}
+    store := NewMemoryStore()
+    store.(*memoryStore).now = clock.Now
+    newLimiter := func() Limiter {
+        l := NewDistributed(store, "api", 4, time.Second).(*distributed)
+        l.now = clock.Now
+        return l
+    }
+    l1 := newLimiter()
+    l2 := newLimiter()
+    ctx := context.Background()
+
+    wait, err := l1.Take(ctx, 3)
+    require.NoError(t, err)
+    require.Zero(t, wait)
+
+    wait, err = l2.Take(ctx, 2)
+    require.NoError(t, err)
+    require.Equal(t, time.Second, wait)
+
+    wait, err = l2.Take(ctx, 1)
+    require.NoError(t, err)
+    require.Zero(t, wait)
+
+    t.Run("Previous window still weighs", func(t *testing.T) {
+        clock.now = clock.now.Add(1500 * time.Millisecond)
+        wait, err := l1.Take(ctx, 3)
+        require.NoError(t, err)
+        require.Equal(t, 500*time.Millisecond, wait)
+
+        wait, err = l1.Take(ctx, 2)
+        require.NoError(t, err)
+        require.Zero(t, wait)
+    })
+}
+
+func TestRateLimitPreHandler(t *testing.T) {
+    th := &zamustest.Handler{}
+    rl := New(NewTokenBucket(1, 2))
+    h := zamus.New(th)
+    h.RegisterPreHandler(rl.PreHandler)
+    h.RegisterBatchPreHandler(rl.BatchPreHandler)
+    ctx := context.Background()
+
+    t.Run("Batch meters per record", func(t *testing.T) {
+        _, err := h.Invoke(ctx, []byte(`[{"id":"1"},{"id":"2"}]`))
+        require.NoError(t, err)
+        require.Equal(t, 1, th.Calls())
+    })
+
+    t.Run("Throttled", func(t *testing.T) {
+        _, err := h.Invoke(ctx, []byte(`{"id":"1"}`))
+        require.True(t, ErrThrottled.Is(err.(errors.Error)))
+        require.Equal(t, 1, th.Calls())
+    })
+}
+
+func TestRateLimitWait(t *testing.T) {
+    rl := New(NewTokenBucket(50, 1))
+    rl.SetWait(true)
+
+    t.Run("Wait within deadline", func(t *testing.T) {
+        ctx := context.Background()
+        require.NoError(t, rl.Take(ctx, 1))
+        require.NoError(t, rl.Take(ctx, 1))
+    })
+
+    t.Run("Wait past deadline", func(t *testing.T) {
+        ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+        defer cancel()
+
+        err := rl.Take(ctx, 1)
+        require.True(t, ErrThrottled.Is(err.(errors.Error)))
+    })
+}
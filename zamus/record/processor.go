@@ -0,0 +1,239 @@
+package record
+
+import (
+    "context"
+    "encoding/json"
+    "sync"
+
+    "github.com/aws/aws-lambda-go/events"
+    jsoniter "github.com/json-iterator/go"
+    "github.com/onedaycat/errors"
+    "github.com/onedaycat/zamus/zamus"
+    "github.com/onedaycat/zamus/zamus/filter"
+)
+
+var jsonen = jsoniter.ConfigCompatibleWithStandardLibrary
+
+var ErrNotProcessed = errors.DefInternalError("NotProcessed", "Record not processed after an earlier record failed")
+
+type SQSHandler func(ctx context.Context, msg *events.SQSMessage) error
+type KinesisHandler func(ctx context.Context, record *events.KinesisEventRecord) error
+type DynamoDBHandler func(ctx context.Context, record *events.DynamoDBEventRecord) error
+
+// FailureHandler is called for every record reported as failed, with the
+// error of the handler, zamus.ErrDeadlineNear or ErrNotProcessed.
+type FailureHandler func(ctx context.Context, id string, err error)
+
+// BatchItemFailure and Response are the partial batch response of SQS,
+// Kinesis and DynamoDB stream event source mappings, which must enable
+// ReportBatchItemFailures.
+type BatchItemFailure struct {
+    ItemIdentifier string `json:"itemIdentifier"`
+}
+
+type Response struct {
+    BatchItemFailures []*BatchItemFailure `json:"batchItemFailures"`
+}
+
+type item struct {
+    id     string
+    raw    json.RawMessage
+    record interface{}
+}
+
+type records struct {
+    Records []json.RawMessage `json:"Records"`
+    items   []*item
+}
+
+// Processor is a zamus.Handler calling its handler once per record of the
+// event and answering with the records which failed, so only those are
+// delivered again.
+type Processor struct {
+    decode      func(raw json.RawMessage) (*item, error)
+    handle      func(ctx context.Context, record interface{}) error
+    ordered     bool
+    concurrency int
+    filter      *filter.Filter
+    onFailure   FailureHandler
+}
+
+// NewSQS processes the messages of a standard queue in any order. Call
+// SetOrdered for a FIFO queue.
+func NewSQS(handler SQSHandler) *Processor {
+    return &Processor{
+        decode: func(raw json.RawMessage) (*item, error) {
+            msg := &events.SQSMessage{}
+            if err := jsonen.Unmarshal(raw, msg); err != nil {
+                return nil, err
+            }
+
+            return &item{id: msg.MessageId, record: msg}, nil
+        },
+        handle: func(ctx context.Context, record interface{}) error {
+            return handler(ctx, record.(*events.SQSMessage))
+        },
+    }
+}
+
+// NewKinesis processes the records of a shard in order. Records are
+// identified by their sequence number.
+func NewKinesis(handler KinesisHandler) *Processor {
+    return &Processor{
+        ordered: true,
+        decode: func(raw json.RawMessage) (*item, error) {
+            record := &events.KinesisEventRecord{}
+            if err := jsonen.Unmarshal(raw, record); err != nil {
+                return nil, err
+            }
+
+            return &item{id: record.Kinesis.SequenceNumber, record: record}, nil
+        },
+        handle: func(ctx context.Context, record interface{}) error {
+            return handler(ctx, record.(*events.KinesisEventRecord))
+        },
+    }
+}
+
+// NewDynamoDB processes the records of a shard in order. Records are
+// identified by their sequence number.
+func NewDynamoDB(handler DynamoDBHandler) *Processor {
+    return &Processor{
+        ordered: true,
+        decode: func(raw json.RawMessage) (*item, error) {
+            record := &events.DynamoDBEventRecord{}
+            if err := jsonen.Unmarshal(raw, record); err != nil {
+                return nil, err
+            }
+
+            return &item{id: record.Change.SequenceNumber, record: record}, nil
+        },
+        handle: func(ctx context.Context, record interface{}) error {
+            return handler(ctx, record.(*events.DynamoDBEventRecord))
+        },
+    }
+}
+
+// SetOrdered stops at the first failing record and reports it with every
+// record after it as failed, so they are delivered again in order.
+func (p *Processor) SetOrdered(ordered bool) {
+    p.ordered = ordered
+}
+
+// SetConcurrency handles up to n records at a time. It is ignored when the
+// processor is ordered.
+func (p *Processor) SetConcurrency(n int) {
+    p.concurrency = n
+}
+
+// SetFilter skips the records which do not match, as if they were processed.
+func (p *Processor) SetFilter(f *filter.Filter) {
+    p.filter = f
+}
+
+func (p *Processor) OnFailure(onFailure FailureHandler) {
+    p.onFailure = onFailure
+}
+
+func (p *Processor) ParseSource(ctx context.Context, payload json.RawMessage) interface{} {
+    src := &records{}
+    if err := jsonen.Unmarshal(payload, src); err != nil {
+        panic(errors.InternalError("UnableParseSource", "UnableParseSource: "+err.Error()))
+    }
+
+    src.items = make([]*item, len(src.Records))
+    for i, raw := range src.Records {
+        it, err := p.decode(raw)
+        if err != nil {
+            panic(errors.InternalError("UnableParseSource", "UnableParseSource: "+err.Error()))
+        }
+        it.raw = raw
+        src.items[i] = it
+    }
+
+    return src
+}
+
+func (p *Processor) ParseSources(ctx context.Context, payload json.RawMessage) interface{} {
+    panic(errors.InternalError("BatchInvokeNotAllowed", "Batch invoke not allowed"))
+}
+
+// Handler stops starting records once zamus.NearDeadline reports the
+// threshold of Handle.SetDeadlineThreshold is reached, and reports the
+// records left as failed with zamus.ErrDeadlineNear.
+func (p *Processor) Handler(ctx context.Context, source interface{}) (interface{}, error) {
+    items := source.(*records).items
+    errs := make([]error, len(items))
+
+    if p.ordered || p.concurrency <= 1 {
+        for i, it := range items {
+            if zamus.NearDeadline(ctx) {
+                errs[i] = zamus.ErrDeadlineNear.New()
+                continue
+            }
+
+            if i > 0 && p.ordered && errs[i-1] != nil {
+                errs[i] = ErrNotProcessed.New()
+                continue
+            }
+
+            errs[i] = p.handleRecord(ctx, it)
+        }
+    } else {
+        var wg sync.WaitGroup
+        sem := make(chan struct{}, p.concurrency)
+        for i, it := range items {
+            sem <- struct{}{}
+            if zamus.NearDeadline(ctx) {
+                errs[i] = zamus.ErrDeadlineNear.New()
+                <-sem
+                continue
+            }
+
+            wg.Add(1)
+            go func(i int, it *item) {
+                defer func() {
+                    <-sem
+                    wg.Done()
+                }()
+
+                errs[i] = p.handleRecord(ctx, it)
+            }(i, it)
+        }
+        wg.Wait()
+    }
+
+    res := &Response{
+        BatchItemFailures: make([]*BatchItemFailure, 0),
+    }
+    for i, err := range errs {
+        if err == nil {
+            continue
+        }
+
+        res.BatchItemFailures = append(res.BatchItemFailures, &BatchItemFailure{ItemIdentifier: items[i].id})
+        if p.onFailure != nil {
+            p.onFailure(ctx, items[i].id, err)
+        }
+    }
+
+    return res, nil
+}
+
+func (p *Processor) BatchHandler(ctx context.Context, sources interface{}) (interface{}, error) {
+    panic(errors.InternalError("BatchInvokeNotAllowed", "Batch invoke not allowed"))
+}
+
+func (p *Processor) handleRecord(ctx context.Context, it *item) (err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            err = zamus.PanicError(r)
+        }
+    }()
+
+    if p.filter != nil && !p.filter.MatchRecord(it.raw) {
+        return nil
+    }
+
+    return p.handle(ctx, it.record)
+}
@@ -0,0 +1,161 @@
+package record
+
+import (
+    "context"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/aws/aws-lambda-go/events"
+    "github.com/onedaycat/errors"
+    "github.com/onedaycat/zamus/zamus"
+    "github.com/onedaycat/zamus/zamus/filter"
+    "github.com/stretchr/testify/require"
+)
+
+const sqsPayload = `{"Records":[
+    {"messageId":"1","body":"{\"type\":\"a\"}","eventSource":"aws:sqs"},
+    {"messageId":"2","body":"fail","eventSource":"aws:sqs"},
+    {"messageId":"3","body":"panic","eventSource":"aws:sqs"},
+    {"messageId":"4","body":"{\"type\":\"b\"}","eventSource":"aws:sqs"}
+]}`
+
+func failures(ids ...string) *Response {
+    res := &Response{BatchItemFailures: make([]*BatchItemFailure, 0)}
+    for _, id := range ids {
+        res.BatchItemFailures = append(res.BatchItemFailures, &BatchItemFailure{ItemIdentifier: id})
+    }
+
+    return res
+}
+
+func TestSQS(t *testing.T) {
+    var mu sync.Mutex
+    var handled []string
+    p := NewSQS(func(ctx context.Context, msg *events.SQSMessage) error {
+        mu.Lock()
+        handled = append(handled, msg.MessageId)
+        mu.Unlock()
+
+        switch msg.Body {
+        case "fail":
+            return errors.InternalError("code1", "msg1")
+        case "panic":
+            panic("boom")
+        }
+
+        return nil
+    })
+    reported := make(map[string]string)
+    p.OnFailure(func(ctx context.Context, id string, err error) {
+        reported[id] = err.(errors.Error).GetCode()
+    })
+    h := zamus.New(p)
+
+    for _, concurrency := range []int{0, 3} {
+        handled = nil
+        p.SetConcurrency(concurrency)
+
+        result, err := h.Invoke(context.Background(), []byte(sqsPayload))
+
+        require.NoError(t, err)
+        require.Equal(t, failures("2", "3"), result)
+        require.ElementsMatch(t, []string{"1", "2", "3", "4"}, handled)
+        require.Equal(t, map[string]string{"2": "code1", "3": "string"}, reported)
+    }
+
+    t.Run("Ordered", func(t *testing.T) {
+        handled = nil
+        reported = make(map[string]string)
+        p.SetOrdered(true)
+        defer p.SetOrdered(false)
+
+        result, err := h.Invoke(context.Background(), []byte(sqsPayload))
+
+        require.NoError(t, err)
+        require.Equal(t, failures("2", "3", "4"), result)
+        require.Equal(t, []string{"1", "2"}, handled)
+        require.Equal(t, map[string]string{"2": "code1", "3": "NotProcessed", "4": "NotProcessed"}, reported)
+    })
+
+    t.Run("Filter", func(t *testing.T) {
+        handled = nil
+        p.SetFilter(filter.MustNew(`{"body":{"type":["b"]}}`))
+        defer p.SetFilter(nil)
+
+        result, err := h.Invoke(context.Background(), []byte(sqsPayload))
+
+        require.NoError(t, err)
+        require.Equal(t, failures(), result)
+        require.Equal(t, []string{"4"}, handled)
+    })
+
+    t.Run("Batch not allowed", func(t *testing.T) {
+        _, err := h.Invoke(context.Background(), []byte(`[`+sqsPayload+`]`))
+
+        require.Equal(t, "BatchInvokeNotAllowed: Batch invoke not allowed", err.Error())
+    })
+}
+
+func TestDeadline(t *testing.T) {
+    var handled []string
+    p := NewSQS(func(ctx context.Context, msg *events.SQSMessage) error {
+        handled = append(handled, msg.MessageId)
+        time.Sleep(30 * time.Millisecond)
+        return nil
+    })
+    reported := make(map[string]string)
+    p.OnFailure(func(ctx context.Context, id string, err error) {
+        reported[id] = err.(errors.Error).GetCode()
+    })
+    h := zamus.New(p)
+    h.SetDeadlineThreshold(time.Second - 20*time.Millisecond)
+    ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+    defer cancel()
+
+    result, err := h.Invoke(ctx, []byte(`{"Records":[{"messageId":"1"},{"messageId":"2"},{"messageId":"3"}]}`))
+
+    require.NoError(t, err)
+    require.Equal(t, failures("2", "3"), result)
+    require.Equal(t, []string{"1"}, handled)
+    require.Equal(t, map[string]string{"2": "DeadlineNear", "3": "DeadlineNear"}, reported)
+}
+
+func TestKinesis(t *testing.T) {
+    var handled []string
+    p := NewKinesis(func(ctx context.Context, record *events.KinesisEventRecord) error {
+        handled = append(handled, string(record.Kinesis.Data))
+        if string(record.Kinesis.Data) == "fail" {
+            return errors.InternalError("code1", "msg1")
+        }
+
+        return nil
+    })
+
+    result, err := zamus.New(p).Invoke(context.Background(), []byte(`{"Records":[
+        {"kinesis":{"sequenceNumber":"11","data":"b2s="},"eventSource":"aws:kinesis"},
+        {"kinesis":{"sequenceNumber":"12","data":"ZmFpbA=="},"eventSource":"aws:kinesis"},
+        {"kinesis":{"sequenceNumber":"13","data":"b2s="},"eventSource":"aws:kinesis"}
+    ]}`))
+
+    require.NoError(t, err)
+    require.Equal(t, failures("12", "13"), result)
+    require.Equal(t, []string{"ok", "fail"}, handled)
+}
+
+func TestDynamoDB(t *testing.T) {
+    var handled []string
+    p := NewDynamoDB(func(ctx context.Context, record *events.DynamoDBEventRecord) error {
+        handled = append(handled, record.Change.NewImage["id"].String())
+        return nil
+    })
+
+    result, err := zamus.New(p).Invoke(context.Background(), []byte(`{"Records":[
+        {"eventName":"INSERT","dynamodb":{"SequenceNumber":"21","NewImage":{"id":{"S":"a"}}}},
+        {"eventName":"MODIFY","dynamodb":{"SequenceNumber":"22","NewImage":{"id":{"S":"b"}}}}
+    ]}`))
+
+    require.NoError(t, err)
+    require.Equal(t, failures(), result)
+    require.Equal(t, []string{"a", "b"}, handled)
+}
@@ -0,0 +1,51 @@
+package zamus
+
+import (
+    "github.com/onedaycat/errors"
+)
+
+// Result is the envelope of one item of a batch response, so a failing item
+// is reported without failing the whole batch.
+type Result struct {
+    Data  interface{}       `json:"data,omitempty"`
+    Error *errors.JSONError `json:"error,omitempty"`
+}
+
+// PartialBatch is the result of a chunked batch stopped before its deadline
+// when BatchHandler does not return []*Result. Results joins the results of
+// the chunks processed, and Unprocessed holds the indexes of the sources not
+// started, which the caller should send again.
+type PartialBatch struct {
+    Results     interface{} `json:"results"`
+    Unprocessed []int       `json:"unprocessed"`
+}
+
+func NewResult(data interface{}, err error) *Result {
+    if err == nil {
+        return &Result{Data: data}
+    }
+
+    return &Result{Error: NewResultError(err)}
+}
+
+// NewResultError keeps the code, message and type of err but leaves out the
+// stacktrace and input, which must not leak to the caller.
+func NewResultError(err error) *errors.JSONError {
+    if xerr, ok := err.(errors.Error); ok {
+        return &errors.JSONError{
+            Code:    xerr.GetCode(),
+            Message: xerr.GetMessage(),
+            ErrType: xerr.GetType(),
+        }
+    }
+
+    return &errors.JSONError{
+        Code:    GetErrorType(err),
+        Message: err.Error(),
+        ErrType: errors.InternalErrorType,
+    }
+}
+
+func (r *Result) Failed() bool {
+    return r.Error != nil
+}
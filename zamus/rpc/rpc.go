@@ -0,0 +1,215 @@
+package rpc
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "reflect"
+
+    jsoniter "github.com/json-iterator/go"
+    "github.com/onedaycat/errors"
+    "github.com/onedaycat/zamus/zamus"
+)
+
+var jsonen = jsoniter.ConfigCompatibleWithStandardLibrary
+
+var (
+    ErrInvalidRequest = errors.DefBadRequest("InvalidRequest", "Invalid RPC request")
+    ErrMethodNotFound = errors.DefBadRequest("MethodNotFound", "Method not found")
+    ErrInvalidParams  = errors.DefBadRequest("InvalidParams", "Invalid params")
+)
+
+var (
+    contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+    errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+type Request struct {
+    Method string          `json:"method"`
+    Params json.RawMessage `json:"params,omitempty"`
+}
+
+type PreHandler func(ctx context.Context, req *Request, params interface{}) (interface{}, error)
+type PostHandler func(ctx context.Context, req *Request, params interface{}, res interface{}, err error) (interface{}, error)
+
+type method struct {
+    fn           reflect.Value
+    params       reflect.Type
+    preHandlers  []PreHandler
+    postHandlers []PostHandler
+}
+
+// Router dispatches {"method":"...","params":{...}} payloads to the method
+// registered under that name. A batch of requests is answered with one
+// zamus.Result per request, in order.
+type Router struct {
+    methods map[string]*method
+}
+
+func New() *Router {
+    return &Router{
+        methods: make(map[string]*method),
+    }
+}
+
+// Register adds a method handler of the form
+//
+//    func(ctx context.Context, params T) (R, error)
+//    func(ctx context.Context) (R, error)
+//
+// params are decoded into a new T before each call. It panics on any other
+// signature so mistakes surface at cold start.
+func (r *Router) Register(name string, fn interface{}) {
+    v := reflect.ValueOf(fn)
+    t := v.Type()
+    if t.Kind() != reflect.Func {
+        panic(fmt.Sprintf("rpc: %s handler must be a func, got %s", name, t))
+    }
+
+    if t.NumIn() < 1 || t.NumIn() > 2 || t.In(0) != contextType {
+        panic(fmt.Sprintf("rpc: %s handler must take (context.Context[, params]), got %s", name, t))
+    }
+
+    if t.NumOut() != 2 || t.Out(1) != errorType {
+        panic(fmt.Sprintf("rpc: %s handler must return (result, error), got %s", name, t))
+    }
+
+    m := &method{fn: v}
+    if t.NumIn() == 2 {
+        m.params = t.In(1)
+    }
+
+    r.methods[name] = m
+}
+
+func (r *Router) RegisterPreHandler(name string, preHandlers ...PreHandler) {
+    m := r.method(name)
+    m.preHandlers = append(m.preHandlers, preHandlers...)
+}
+
+func (r *Router) RegisterPostHandler(name string, postHandlers ...PostHandler) {
+    m := r.method(name)
+    m.postHandlers = append(m.postHandlers, postHandlers...)
+}
+
+func (r *Router) method(name string) *method {
+    m, ok := r.methods[name]
+    if !ok {
+        panic(fmt.Sprintf("rpc: method %s is not registered", name))
+    }
+
+    return m
+}
+
+func (r *Router) ParseSource(ctx context.Context, payload json.RawMessage) interface{} {
+    req := &Request{}
+    if err := jsonen.Unmarshal(payload, req); err != nil {
+        panic(ErrInvalidRequest.New("Unable to parse request: " + err.Error()))
+    }
+
+    return req
+}
+
+func (r *Router) ParseSources(ctx context.Context, payload json.RawMessage) interface{} {
+    reqs := make([]*Request, 0, 10)
+    if err := jsonen.Unmarshal(payload, &reqs); err != nil {
+        panic(ErrInvalidRequest.New("Unable to parse requests: " + err.Error()))
+    }
+
+    return reqs
+}
+
+// Handler returns the method result or its error, which the Handle may
+// retry. An unknown method is answered with a zamus.Result error envelope
+// since retrying it cannot succeed.
+func (r *Router) Handler(ctx context.Context, source interface{}) (interface{}, error) {
+    req := source.(*Request)
+    m, ok := r.methods[req.Method]
+    if !ok {
+        return zamus.NewResult(nil, ErrMethodNotFound.Newf("Method %s not found", req.Method)), nil
+    }
+
+    return m.call(ctx, req)
+}
+
+// BatchHandler answers each request on its own, so a failing or panicking
+// method does not fail the other requests.
+func (r *Router) BatchHandler(ctx context.Context, sources interface{}) (interface{}, error) {
+    reqs := sources.([]*Request)
+    results := make([]*zamus.Result, len(reqs))
+    for i, req := range reqs {
+        results[i] = r.handleItem(ctx, req)
+    }
+
+    return results, nil
+}
+
+func (r *Router) handleItem(ctx context.Context, req *Request) (result *zamus.Result) {
+    defer func() {
+        if rec := recover(); rec != nil {
+            result = zamus.NewResult(nil, zamus.PanicError(rec))
+        }
+    }()
+
+    if req == nil {
+        return zamus.NewResult(nil, ErrInvalidRequest.New("Request is null"))
+    }
+
+    m, ok := r.methods[req.Method]
+    if !ok {
+        return zamus.NewResult(nil, ErrMethodNotFound.Newf("Method %s not found", req.Method))
+    }
+
+    return zamus.NewResult(m.call(ctx, req))
+}
+
+func (m *method) call(ctx context.Context, req *Request) (interface{}, error) {
+    args := []reflect.Value{reflect.ValueOf(ctx)}
+    var params interface{}
+    if m.params != nil {
+        pv, err := m.decode(req.Params)
+        if err != nil {
+            return nil, err
+        }
+        params = pv.Interface()
+        args = append(args, pv)
+    }
+
+    for _, ph := range m.preHandlers {
+        result, err := ph(ctx, req, params)
+        if err != nil || result != nil {
+            return result, err
+        }
+    }
+
+    out := m.fn.Call(args)
+    result := out[0].Interface()
+    err, _ := out[1].Interface().(error)
+
+    for _, ph := range m.postHandlers {
+        result, err = ph(ctx, req, params, result, err)
+    }
+
+    return result, err
+}
+
+func (m *method) decode(params json.RawMessage) (reflect.Value, error) {
+    isPtr := m.params.Kind() == reflect.Ptr
+    t := m.params
+    if isPtr {
+        t = t.Elem()
+    }
+
+    pv := reflect.New(t)
+    if len(params) > 0 {
+        if err := jsonen.Unmarshal(params, pv.Interface()); err != nil {
+            return reflect.Value{}, ErrInvalidParams.New("Unable to decode params: " + err.Error())
+        }
+    }
+
+    if isPtr {
+        return pv, nil
+    }
+
+    return pv.Elem(), nil
+}
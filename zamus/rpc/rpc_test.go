@@ -0,0 +1,157 @@
+package rpc
+
+import (
+    "context"
+    "testing"
+
+    "github.com/onedaycat/errors"
+    "github.com/onedaycat/zamus/zamus"
+    "github.com/stretchr/testify/require"
+)
+
+type createOrder struct {
+    ID  string `json:"id"`
+    Qty int    `json:"qty"`
+}
+
+type order struct {
+    ID    string
+    Total int
+}
+
+func newTestRouter() *Router {
+    r := New()
+    r.Register("createOrder", func(ctx context.Context, params *createOrder) (*order, error) {
+        if params.Qty == 0 {
+            return nil, errors.BadRequest("InvalidQty", "qty is required")
+        }
+
+        return &order{ID: params.ID, Total: params.Qty * 10}, nil
+    })
+    r.Register("countOrders", func(ctx context.Context, params map[string]int) (int, error) {
+        return params["n"], nil
+    })
+    r.Register("ping", func(ctx context.Context) (string, error) {
+        return "pong", nil
+    })
+    r.Register("crash", func(ctx context.Context) (string, error) {
+        panic("boom")
+    })
+
+    return r
+}
+
+func TestRouter(t *testing.T) {
+    h := zamus.New(newTestRouter())
+    ctx := context.Background()
+
+    t.Run("Pointer params", func(t *testing.T) {
+        result, err := h.Invoke(ctx, []byte(`{"method":"createOrder","params":{"id":"o1","qty":2}}`))
+
+        require.NoError(t, err)
+        require.Equal(t, &order{ID: "o1", Total: 20}, result)
+    })
+
+    t.Run("Value params", func(t *testing.T) {
+        result, err := h.Invoke(ctx, []byte(`{"method":"countOrders","params":{"n":3}}`))
+
+        require.NoError(t, err)
+        require.Equal(t, 3, result)
+    })
+
+    t.Run("No params", func(t *testing.T) {
+        result, err := h.Invoke(ctx, []byte(`{"method":"ping"}`))
+
+        require.NoError(t, err)
+        require.Equal(t, "pong", result)
+    })
+
+    t.Run("Method error", func(t *testing.T) {
+        result, err := h.Invoke(ctx, []byte(`{"method":"createOrder","params":{"id":"o1"}}`))
+
+        require.Equal(t, "InvalidQty: qty is required", err.Error())
+        require.Nil(t, result)
+    })
+
+    t.Run("Invalid params", func(t *testing.T) {
+        _, err := h.Invoke(ctx, []byte(`{"method":"createOrder","params":{"qty":"x"}}`))
+
+        require.True(t, ErrInvalidParams.Is(err.(errors.Error)))
+    })
+
+    t.Run("Unknown method", func(t *testing.T) {
+        result, err := h.Invoke(ctx, []byte(`{"method":"deleteOrder"}`))
+
+        require.NoError(t, err)
+        require.Equal(t, &zamus.Result{Error: &errors.JSONError{
+            Code:    "MethodNotFound",
+            Message: "Method deleteOrder not found",
+            ErrType: errors.BadRequestType,
+        }}, result)
+    })
+}
+
+func TestRouterBatch(t *testing.T) {
+    h := zamus.New(newTestRouter())
+    ctx := context.Background()
+
+    result, err := h.Invoke(ctx, []byte(`[
+        {"method":"createOrder","params":{"id":"o1","qty":1}},
+        {"method":"createOrder","params":{"id":"o2"}},
+        {"method":"unknown"},
+        {"method":"crash"},
+        null,
+        {"method":"ping"}
+    ]`))
+
+    require.NoError(t, err)
+    results := result.([]*zamus.Result)
+    require.Len(t, results, 6)
+    require.Equal(t, &order{ID: "o1", Total: 10}, results[0].Data)
+    require.Equal(t, "InvalidQty", results[1].Error.Code)
+    require.Equal(t, "MethodNotFound", results[2].Error.Code)
+    require.Equal(t, &errors.JSONError{Code: "string", Message: "boom", ErrType: errors.InternalErrorType}, results[3].Error)
+    require.Equal(t, &errors.JSONError{Code: "InvalidRequest", Message: "Request is null", ErrType: errors.BadRequestType}, results[4].Error)
+    require.Equal(t, "pong", results[5].Data)
+}
+
+func TestRouterPrePostHandler(t *testing.T) {
+    r := newTestRouter()
+    r.RegisterPreHandler("createOrder", func(ctx context.Context, req *Request, params interface{}) (interface{}, error) {
+        if params.(*createOrder).ID == "" {
+            return nil, errors.Unauthorized("NoID", "id is required")
+        }
+
+        return nil, nil
+    })
+    r.RegisterPostHandler("createOrder", func(ctx context.Context, req *Request, params interface{}, res interface{}, err error) (interface{}, error) {
+        if err != nil {
+            return nil, err
+        }
+        res.(*order).Total++
+
+        return res, nil
+    })
+    h := zamus.New(r)
+    ctx := context.Background()
+
+    result, err := h.Invoke(ctx, []byte(`{"method":"createOrder","params":{"id":"o1","qty":1}}`))
+    require.NoError(t, err)
+    require.Equal(t, &order{ID: "o1", Total: 11}, result)
+
+    _, err = h.Invoke(ctx, []byte(`{"method":"createOrder","params":{"qty":1}}`))
+    require.Equal(t, "NoID: id is required", err.Error())
+
+    result, err = h.Invoke(ctx, []byte(`{"method":"ping"}`))
+    require.NoError(t, err)
+    require.Equal(t, "pong", result)
+}
+
+func TestRegisterInvalid(t *testing.T) {
+    r := New()
+
+    require.Panics(t, func() { r.Register("a", "notfunc") })
+    require.Panics(t, func() { r.Register("a", func(params string) (string, error) { return "", nil }) })
+    require.Panics(t, func() { r.Register("a", func(ctx context.Context) string { return "" }) })
+    require.Panics(t, func() { r.RegisterPreHandler("missing") })
+}
@@ -0,0 +1,154 @@
+package schema
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "strconv"
+    "strings"
+
+    "github.com/onedaycat/errors"
+    "github.com/onedaycat/zamus/zamus"
+    "github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+const schemaURL = "zamus://schema.json"
+
+var (
+    ErrInvalidPayload = errors.DefBadRequest("InvalidPayload", "Payload does not match schema")
+    ErrInvalidSchema  = errors.DefInternalError("InvalidSchema", "Unable to compile schema")
+)
+
+type Violation struct {
+    Pointer string `json:"pointer"`
+    Keyword string `json:"keyword"`
+    Message string `json:"message"`
+}
+
+type Validator struct {
+    schema *jsonschema.Schema
+    items  bool
+}
+
+// New compiles a JSON Schema once, so it should be called at cold start.
+// The draft is taken from $schema, draft 2020-12 when missing.
+func New(schema string) (*Validator, error) {
+    compiler := jsonschema.NewCompiler()
+    compiler.Draft = jsonschema.Draft2020
+    if err := compiler.AddResource(schemaURL, strings.NewReader(schema)); err != nil {
+        return nil, ErrInvalidSchema.New().WithCause(err)
+    }
+
+    compiled, err := compiler.Compile(schemaURL)
+    if err != nil {
+        return nil, ErrInvalidSchema.New().WithCause(err)
+    }
+
+    return &Validator{
+        schema: compiled,
+    }, nil
+}
+
+func MustNew(schema string) *Validator {
+    v, err := New(schema)
+    if err != nil {
+        panic(err)
+    }
+
+    return v
+}
+
+// SetItems validates every element of a batch array against the schema
+// instead of validating the array as a whole.
+func (v *Validator) SetItems(items bool) {
+    v.items = items
+}
+
+// Middleware validates the payload as Handle parses it, so a byte order
+// mark is ignored and an empty or null payload is validated as the default
+// payload. Handle reports ErrEmptyPayload when there is none.
+func (v *Validator) Middleware(next zamus.InvokeHandler) zamus.InvokeHandler {
+    return func(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+        prepared, err := zamus.PreparePayload(ctx, payload)
+        if err != nil {
+            return next(ctx, payload)
+        }
+
+        if err := v.Validate(prepared); err != nil {
+            return nil, err
+        }
+
+        return next(ctx, payload)
+    }
+}
+
+// Validate returns ErrInvalidPayload with the list of violations as input.
+func (v *Validator) Validate(payload json.RawMessage) errors.Error {
+    instance, err := decode(payload)
+    if err != nil {
+        return ErrInvalidPayload.Newf("Unable to decode payload: %s", err.Error())
+    }
+
+    var violations []*Violation
+    if items, ok := instance.([]interface{}); ok && v.items {
+        for i, item := range items {
+            violations = append(violations, v.validate(item, "/"+strconv.Itoa(i))...)
+        }
+    } else {
+        violations = v.validate(instance, "")
+    }
+
+    if len(violations) == 0 {
+        return nil
+    }
+
+    msgs := make([]string, len(violations))
+    for i, violation := range violations {
+        msgs[i] = violation.Pointer + ": " + violation.Message
+    }
+
+    return ErrInvalidPayload.New(strings.Join(msgs, "; ")).WithInput(violations)
+}
+
+func (v *Validator) validate(instance interface{}, prefix string) []*Violation {
+    err := v.schema.Validate(instance)
+    if err == nil {
+        return nil
+    }
+
+    verr, ok := err.(*jsonschema.ValidationError)
+    if !ok {
+        return []*Violation{{Pointer: prefix, Message: err.Error()}}
+    }
+
+    var violations []*Violation
+    var flatten func(verr *jsonschema.ValidationError)
+    flatten = func(verr *jsonschema.ValidationError) {
+        if len(verr.Causes) == 0 {
+            violations = append(violations, &Violation{
+                Pointer: prefix + verr.InstanceLocation,
+                Keyword: verr.KeywordLocation[strings.LastIndex(verr.KeywordLocation, "/")+1:],
+                Message: verr.Message,
+            })
+            return
+        }
+
+        for _, cause := range verr.Causes {
+            flatten(cause)
+        }
+    }
+    flatten(verr)
+
+    return violations
+}
+
+func decode(payload json.RawMessage) (interface{}, error) {
+    var instance interface{}
+    dec := json.NewDecoder(bytes.NewReader(payload))
+    dec.UseNumber()
+    if err := dec.Decode(&instance); err != nil {
+        return nil, err
+    }
+
+    return instance, nil
+}
@@ -0,0 +1,121 @@
+package schema
+
+import (
+    "context"
+    "testing"
+
+    "github.com/onedaycat/errors"
+    "github.com/onedaycat/zamus/zamus"
+    "github.com/onedaycat/zamus/zamus/zamustest"
+    "github.com/stretchr/testify/require"
+)
+
+const testSchema = `{
+    "type": "object",
+    "required": ["id"],
+    "properties": {
+        "id": {"type": "string"},
+        "qty": {"type": "integer", "minimum": 1}
+    }
+}`
+
+func TestValidate(t *testing.T) {
+    v := MustNew(testSchema)
+
+    t.Run("Valid", func(t *testing.T) {
+        require.Nil(t, v.Validate([]byte(`{"id":"1","qty":2}`)))
+    })
+
+    t.Run("Every violation", func(t *testing.T) {
+        err := v.Validate([]byte(`{"qty":0}`))
+
+        require.True(t, ErrInvalidPayload.Is(err))
+        require.Equal(t, errors.BadRequestType, err.GetType())
+        require.ElementsMatch(t, []*Violation{
+            {Pointer: "", Keyword: "required", Message: "missing properties: 'id'"},
+            {Pointer: "/qty", Keyword: "minimum", Message: "must be >= 1 but found 0"},
+        }, err.GetInput())
+    })
+
+    t.Run("Invalid JSON", func(t *testing.T) {
+        err := v.Validate([]byte(`{"id":`))
+
+        require.True(t, ErrInvalidPayload.Is(err))
+    })
+}
+
+func TestValidateItems(t *testing.T) {
+    v := MustNew(testSchema)
+    v.SetItems(true)
+
+    err := v.Validate([]byte(`[{"id":"1"},{"id":2}]`))
+
+    require.True(t, ErrInvalidPayload.Is(err))
+    require.Equal(t, []*Violation{
+        {Pointer: "/1/id", Keyword: "type", Message: "expected string, but got number"},
+    }, err.GetInput())
+}
+
+func TestDraft7(t *testing.T) {
+    v := MustNew(`{
+        "$schema": "http://json-schema.org/draft-07/schema#",
+        "items": [{"type": "string"}],
+        "additionalItems": false
+    }`)
+
+    require.Nil(t, v.Validate([]byte(`["a"]`)))
+    require.NotNil(t, v.Validate([]byte(`["a","b"]`)))
+}
+
+func TestInvalidSchema(t *testing.T) {
+    _, err := New(`{"type": 1}`)
+
+    require.True(t, ErrInvalidSchema.Is(err.(errors.Error)))
+}
+
+func TestMiddleware(t *testing.T) {
+    th := &zamustest.Handler{}
+    h := zamus.New(th)
+    h.Use(MustNew(testSchema).Middleware)
+    ctx := context.Background()
+
+    _, err := h.Invoke(ctx, []byte(`{"id":"1"}`))
+    require.NoError(t, err)
+    require.Equal(t, 1, th.Calls())
+
+    _, err = h.Invoke(ctx, []byte(`{"id":1}`))
+    require.Equal(t, "InvalidPayload: /id: expected string, but got number", err.Error())
+    require.Equal(t, 1, th.Calls())
+}
+
+func TestMiddlewarePreparePayload(t *testing.T) {
+    th := &zamustest.Handler{}
+    h := zamus.New(th)
+    h.Use(MustNew(testSchema).Middleware)
+    ctx := context.Background()
+
+    t.Run("Byte order mark", func(t *testing.T) {
+        _, err := h.Invoke(ctx, []byte("\xEF\xBB\xBF {\"id\":\"1\"}\n"))
+        require.NoError(t, err)
+        require.Equal(t, 1, th.Calls())
+    })
+
+    t.Run("Empty payload", func(t *testing.T) {
+        _, err := h.Invoke(ctx, []byte(` null `))
+        require.True(t, zamus.ErrEmptyPayload.Is(err.(errors.Error)))
+        require.Equal(t, 1, th.Calls())
+    })
+
+    t.Run("Default payload", func(t *testing.T) {
+        h.SetDefaultPayload([]byte(`{"id":2}`))
+
+        _, err := h.Invoke(ctx, []byte(`null`))
+        require.Equal(t, "InvalidPayload: /id: expected string, but got number", err.Error())
+
+        h.SetDefaultPayload([]byte(`{"id":"2"}`))
+
+        _, err = h.Invoke(ctx, []byte(``))
+        require.NoError(t, err)
+        require.Equal(t, 2, th.Calls())
+    })
+}
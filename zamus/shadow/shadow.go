@@ -0,0 +1,290 @@
+package shadow
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "math/rand"
+    "sync"
+    "time"
+
+    "github.com/aws/aws-lambda-go/lambdacontext"
+    "github.com/onedaycat/errors"
+    "github.com/onedaycat/zamus/logger"
+    "github.com/onedaycat/zamus/zamus"
+)
+
+// Outcome is what a handler answered for the mirrored payload.
+type Outcome struct {
+    Result interface{}
+    Err    error
+}
+
+// Comparator reports whether the candidate answered the same as the primary.
+type Comparator func(primary, candidate *Outcome) bool
+
+// Entry is logged for every mismatch or candidate failure.
+type Entry struct {
+    RequestID  string      `json:"requestId,omitempty"`
+    Input      interface{} `json:"input,omitempty"`
+    Primary    interface{} `json:"primary,omitempty"`
+    Candidate  interface{} `json:"candidate,omitempty"`
+    PrimaryErr string      `json:"primaryErr,omitempty"`
+    ErrCode    string      `json:"errCode,omitempty"`
+    Panicked   bool        `json:"panicked,omitempty"`
+    DurationMS float64     `json:"durationMs"`
+}
+
+// Shadow mirrors invocations to a candidate Handler. The candidate only runs
+// once the primary has answered, and its result, error or panic never
+// reaches the caller.
+type Shadow struct {
+    candidate  *zamus.Handle
+    log        logger.Logger
+    sampleRate float64
+    timeout    time.Duration
+    reserve    time.Duration
+    compare    Comparator
+    async      bool
+    wg         sync.WaitGroup
+    random     func() float64
+}
+
+func New(candidate zamus.Handler, log logger.Logger) *Shadow {
+    return &Shadow{
+        candidate:  zamus.New(candidate),
+        log:        log,
+        sampleRate: 1,
+        timeout:    time.Second,
+        reserve:    100 * time.Millisecond,
+        compare:    Equal,
+        random:     rand.Float64,
+    }
+}
+
+// SetSampleRate mirrors only this ratio of invocations.
+func (s *Shadow) SetSampleRate(rate float64) {
+    s.sampleRate = rate
+}
+
+// SetTimeout is the time budget of the candidate. It is also bounded by the
+// deadline of the invocation minus the reserve unless the candidate runs
+// asynchronously.
+func (s *Shadow) SetTimeout(timeout time.Duration) {
+    s.timeout = timeout
+}
+
+// SetReserve keeps this much of the invocation time for the primary response
+// to be returned, 100ms by default. Invocations with less time left are not
+// mirrored.
+func (s *Shadow) SetReserve(reserve time.Duration) {
+    s.reserve = reserve
+}
+
+func (s *Shadow) SetComparator(compare Comparator) {
+    s.compare = compare
+}
+
+// SetAsync returns the primary response without waiting for the candidate.
+// Lambda freezes the environment once the response is returned, so the
+// candidate may only finish during a later invocation; call Wait where the
+// process must not exit first.
+func (s *Shadow) SetAsync(async bool) {
+    s.async = async
+}
+
+// Wait blocks until every asynchronous candidate has finished.
+func (s *Shadow) Wait() {
+    s.wg.Wait()
+}
+
+func (s *Shadow) Middleware(next zamus.InvokeHandler) zamus.InvokeHandler {
+    return func(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+        result, err := next(ctx, payload)
+
+        if s.sampleRate <= 0 || (s.sampleRate < 1 && s.random() >= s.sampleRate) {
+            return result, err
+        }
+
+        primary := &Outcome{Result: result, Err: err}
+        mirrored := append(json.RawMessage(nil), payload...)
+        shadowCtx, cancel, ok := s.isolate(ctx)
+        if !ok {
+            s.log.Debug("Shadow skipped near the deadline", &Entry{
+                RequestID: requestID(ctx),
+            })
+            return result, err
+        }
+
+        if !s.async {
+            defer cancel()
+            s.run(shadowCtx, mirrored, primary)
+            return result, err
+        }
+
+        s.wg.Add(1)
+        go func() {
+            defer s.wg.Done()
+            defer cancel()
+            s.run(shadowCtx, mirrored, primary)
+        }()
+
+        return result, err
+    }
+}
+
+// isolate starts a context which shares nothing with the invocation but
+// the Lambda request metadata, so cancelling one never affects the other.
+// It fails when the reserve leaves no time to the candidate.
+func (s *Shadow) isolate(ctx context.Context) (context.Context, context.CancelFunc, bool) {
+    shadowCtx := context.Background()
+    if lc, ok := lambdacontext.FromContext(ctx); ok {
+        shadowCtx = lambdacontext.NewContext(shadowCtx, lc)
+    }
+
+    deadline := time.Now().Add(s.timeout)
+    if d, ok := ctx.Deadline(); ok && !s.async {
+        d = d.Add(-s.reserve)
+        if !time.Now().Before(d) {
+            return nil, nil, false
+        }
+
+        if d.Before(deadline) {
+            deadline = d
+        }
+    }
+
+    shadowCtx, cancel := context.WithDeadline(shadowCtx, deadline)
+
+    return shadowCtx, cancel, true
+}
+
+func (s *Shadow) run(ctx context.Context, payload json.RawMessage, primary *Outcome) {
+    start := time.Now()
+    entry := &Entry{
+        RequestID: requestID(ctx),
+    }
+
+    defer func() {
+        if r := recover(); r != nil {
+            entry.Panicked = true
+            s.report(entry, payload, primary, nil, start, fmt.Sprintf("Shadow comparison panicked: %v", r))
+        }
+    }()
+
+    candidate, ok := s.invoke(ctx, payload)
+    if !ok {
+        s.report(entry, payload, primary, nil, start, "Shadow candidate timed out")
+        return
+    }
+
+    if candidate.Err != nil && primary.Err == nil {
+        if xerr, ok := candidate.Err.(errors.Error); ok {
+            entry.Panicked = xerr.IsPanic()
+        }
+        s.report(entry, payload, primary, candidate, start, "Shadow candidate failed")
+        return
+    }
+
+    if !s.compare(primary, candidate) {
+        s.report(entry, payload, primary, candidate, start, "Shadow mismatch")
+        return
+    }
+
+    s.log.Debug("Shadow matched", &Entry{
+        RequestID:  entry.RequestID,
+        DurationMS: duration(start),
+    })
+}
+
+// invoke abandons a candidate which does not return within its time budget
+// so the primary never waits longer than the budget.
+func (s *Shadow) invoke(ctx context.Context, payload json.RawMessage) (*Outcome, bool) {
+    done := make(chan *Outcome, 1)
+    go func() {
+        candidate := &Outcome{}
+        candidate.Result, candidate.Err = s.candidate.Invoke(ctx, payload)
+        done <- candidate
+    }()
+
+    select {
+    case candidate := <-done:
+        return candidate, true
+    case <-ctx.Done():
+        return nil, false
+    }
+}
+
+func (s *Shadow) report(entry *Entry, payload json.RawMessage, primary, candidate *Outcome, start time.Time, msg string) {
+    entry.DurationMS = duration(start)
+    entry.Input = payload
+    entry.Primary = primary.Result
+    if primary.Err != nil {
+        entry.PrimaryErr = primary.Err.Error()
+    }
+
+    if candidate != nil {
+        entry.Candidate = candidate.Result
+        if candidate.Err != nil {
+            entry.ErrCode = zamus.NewResultError(candidate.Err).Code
+            entry.Candidate = candidate.Err.Error()
+        }
+    }
+
+    s.log.Warn(msg, entry)
+}
+
+func requestID(ctx context.Context) string {
+    if lc, ok := lambdacontext.FromContext(ctx); ok {
+        return lc.AwsRequestID
+    }
+
+    return ""
+}
+
+func duration(start time.Time) float64 {
+    return float64(time.Since(start)) / float64(time.Millisecond)
+}
+
+// Equal is the default Comparator. Results match when their JSON encodings
+// are equal, errors when their codes are equal.
+func Equal(primary, candidate *Outcome) bool {
+    if primary.Err != nil || candidate.Err != nil {
+        if primary.Err == nil || candidate.Err == nil {
+            return false
+        }
+
+        return zamus.NewResultError(primary.Err).Code == zamus.NewResultError(candidate.Err).Code
+    }
+
+    p, err := canonical(primary.Result)
+    if err != nil {
+        return false
+    }
+
+    c, err := canonical(candidate.Result)
+    if err != nil {
+        return false
+    }
+
+    return bytes.Equal(p, c)
+}
+
+// canonical re-encodes a value so struct and map results compare equal
+// regardless of field order.
+func canonical(value interface{}) ([]byte, error) {
+    data, err := json.Marshal(value)
+    if err != nil {
+        return nil, err
+    }
+
+    var v interface{}
+    dec := json.NewDecoder(bytes.NewReader(data))
+    dec.UseNumber()
+    if err = dec.Decode(&v); err != nil {
+        return nil, err
+    }
+
+    return json.Marshal(v)
+}
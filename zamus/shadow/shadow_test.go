@@ -0,0 +1,203 @@
+package shadow
+
+import (
+    "context"
+    "encoding/json"
+    "testing"
+    "time"
+
+    "github.com/onedaycat/errors"
+    "github.com/onedaycat/zamus/zamus"
+    "github.com/onedaycat/zamus/zamus/zamustest"
+    "github.com/stretchr/testify/require"
+)
+
+type testRes struct {
+    Name string `json:"name"`
+    Age  int    `json:"age"`
+}
+
+func TestShadow(t *testing.T) {
+    log := &zamustest.Logger{}
+    candidate := &zamustest.Handler{}
+    s := New(candidate, log)
+    s.SetTimeout(20 * time.Millisecond)
+
+    h := zamus.New(&zamustest.Handler{
+        HandlerFunc: func(ctx context.Context, source interface{}) (interface{}, error) {
+            return &testRes{Name: "a", Age: 1}, nil
+        },
+    })
+    h.Use(s.Middleware)
+
+    tests := []struct {
+        name      string
+        candidate func(ctx context.Context, source interface{}) (interface{}, error)
+        msg       string
+        entry     *Entry
+    }{
+        {
+            name: "Match",
+            candidate: func(ctx context.Context, source interface{}) (interface{}, error) {
+                return map[string]interface{}{"age": 1, "name": "a"}, nil
+            },
+            msg:   "Shadow matched",
+            entry: &Entry{},
+        },
+        {
+            name: "Mismatch",
+            candidate: func(ctx context.Context, source interface{}) (interface{}, error) {
+                return &testRes{Name: "b", Age: 1}, nil
+            },
+            msg: "Shadow mismatch",
+            entry: &Entry{
+                Input:     json.RawMessage(`{"id":"1"}`),
+                Primary:   &testRes{Name: "a", Age: 1},
+                Candidate: &testRes{Name: "b", Age: 1},
+            },
+        },
+        {
+            name: "Error",
+            candidate: func(ctx context.Context, source interface{}) (interface{}, error) {
+                return nil, errors.InternalError("code1", "msg1")
+            },
+            msg: "Shadow candidate failed",
+            entry: &Entry{
+                Input:     json.RawMessage(`{"id":"1"}`),
+                Primary:   &testRes{Name: "a", Age: 1},
+                Candidate: "code1: msg1",
+                ErrCode:   "code1",
+            },
+        },
+        {
+            name: "Panic",
+            candidate: func(ctx context.Context, source interface{}) (interface{}, error) {
+                panic("boom")
+            },
+            msg: "Shadow candidate failed",
+            entry: &Entry{
+                Input:     json.RawMessage(`{"id":"1"}`),
+                Primary:   &testRes{Name: "a", Age: 1},
+                Candidate: "string: boom",
+                ErrCode:   "string",
+                Panicked:  true,
+            },
+        },
+        {
+            name: "Timeout",
+            candidate: func(ctx context.Context, source interface{}) (interface{}, error) {
+                time.Sleep(time.Second)
+                return nil, nil
+            },
+            msg: "Shadow candidate timed out",
+            entry: &Entry{
+                Input:   json.RawMessage(`{"id":"1"}`),
+                Primary: &testRes{Name: "a", Age: 1},
+            },
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            log.Reset()
+            candidate.HandlerFunc = tt.candidate
+
+            result, err := h.Invoke(context.Background(), []byte(`{"id":"1"}`))
+
+            require.NoError(t, err)
+            require.Equal(t, &testRes{Name: "a", Age: 1}, result)
+            require.Len(t, log.Lines(), 1)
+            require.Equal(t, tt.msg, log.Lines()[0].Msg)
+            entry := log.Lines()[0].Data[0].(*Entry)
+            entry.DurationMS = 0
+            require.Equal(t, tt.entry, entry)
+        })
+    }
+}
+
+func TestShadowDeadline(t *testing.T) {
+    log := &zamustest.Logger{}
+    called := make(chan struct{}, 1)
+    block := make(chan struct{})
+    defer close(block)
+    s := New(&zamustest.Handler{
+        HandlerFunc: func(ctx context.Context, source interface{}) (interface{}, error) {
+            called <- struct{}{}
+            <-block
+            return nil, nil
+        },
+    }, log)
+    s.SetTimeout(time.Minute)
+    s.SetReserve(100 * time.Millisecond)
+
+    h := zamus.New(&zamustest.Handler{
+        HandlerFunc: func(ctx context.Context, source interface{}) (interface{}, error) {
+            return &testRes{Name: "a", Age: 1}, nil
+        },
+    })
+    h.Use(s.Middleware)
+
+    t.Run("Candidate blocks", func(t *testing.T) {
+        log.Reset()
+        ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+        defer cancel()
+
+        result, err := h.Invoke(ctx, []byte(`{"id":"1"}`))
+
+        require.NoError(t, err)
+        require.Equal(t, &testRes{Name: "a", Age: 1}, result)
+        require.NoError(t, ctx.Err())
+        require.Len(t, called, 1)
+        <-called
+        require.Equal(t, "Shadow candidate timed out", log.Lines()[0].Msg)
+    })
+
+    t.Run("Skipped under the reserve", func(t *testing.T) {
+        log.Reset()
+        ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+        defer cancel()
+
+        result, err := h.Invoke(ctx, []byte(`{"id":"1"}`))
+
+        require.NoError(t, err)
+        require.Equal(t, &testRes{Name: "a", Age: 1}, result)
+        require.Empty(t, called)
+        require.Equal(t, "Shadow skipped near the deadline", log.Lines()[0].Msg)
+    })
+}
+
+func TestShadowSampling(t *testing.T) {
+    log := &zamustest.Logger{}
+    called := 0
+    s := New(&zamustest.Handler{
+        HandlerFunc: func(ctx context.Context, source interface{}) (interface{}, error) {
+            called++
+            return nil, errors.InternalError("code1", "msg1")
+        },
+    }, log)
+    s.SetSampleRate(0.5)
+    s.random = func() float64 { return 0.7 }
+
+    h := zamus.New(&zamustest.Handler{
+        HandlerFunc: func(ctx context.Context, source interface{}) (interface{}, error) {
+            return nil, errors.InternalError("code1", "msg2")
+        },
+    })
+    h.Use(s.Middleware)
+
+    _, err := h.Invoke(context.Background(), []byte(`{"id":"1"}`))
+    require.Equal(t, "code1: msg2", err.Error())
+    require.Equal(t, 0, called)
+
+    t.Run("Async", func(t *testing.T) {
+        s.random = func() float64 { return 0.2 }
+        s.SetAsync(true)
+
+        _, err := h.Invoke(context.Background(), []byte(`{"id":"1"}`))
+        s.Wait()
+
+        require.Equal(t, "code1: msg2", err.Error())
+        require.Equal(t, 1, called)
+        require.Equal(t, "Shadow matched", log.Lines()[0].Msg)
+    })
+}
@@ -3,10 +3,13 @@ package source
 import (
     "context"
     "encoding/json"
+    "reflect"
+    "sync"
 
     "github.com/aws/aws-lambda-go/events"
     jsoniter "github.com/json-iterator/go"
     "github.com/onedaycat/errors"
+    "github.com/onedaycat/zamus/zamus"
 )
 
 var jsonen = jsoniter.ConfigCompatibleWithStandardLibrary
@@ -47,6 +50,22 @@ type Handler struct {
     cognitoPreSignUpHandler                  CognitoPreSignUpHandler
     cognitoPostConfirmHandler                CognitoPostConfirmHandler
     cognitoPreTokenHandler                   CognitoPreTokenHandler
+    batch                                    bool
+    batchConcurrency                         int
+}
+
+// SetBatch accepts array payloads. Each element is passed to the single
+// event handler and the batch answers with one zamus.Result per element, so
+// a failing element does not fail the others. Elements not started before
+// the threshold of Handle.SetDeadlineThreshold fail with
+// zamus.ErrDeadlineNear.
+func (h *Handler) SetBatch(batch bool) {
+    h.batch = batch
+}
+
+// SetBatchConcurrency handles up to n elements of a batch at a time.
+func (h *Handler) SetBatchConcurrency(n int) {
+    h.batchConcurrency = n
 }
 
 func (h *Handler) ParseSource(ctx context.Context, payload json.RawMessage) interface{} {
@@ -64,11 +83,82 @@ func (h *Handler) ParseSource(ctx context.Context, payload json.RawMessage) inte
 }
 
 func (h *Handler) ParseSources(ctx context.Context, payload json.RawMessage) interface{} {
-    panic(errors.InternalError("BatchInvokeNotAllowed", "Batch invoke not allowed"))
+    if !h.batch {
+        panic(errors.InternalError("BatchInvokeNotAllowed", "Batch invoke not allowed"))
+    }
+
+    var sourceType reflect.Type = reflect.TypeOf(json.RawMessage{})
+    if h.source != nil {
+        sourceType = reflect.TypeOf(h.source())
+    }
+
+    sources := reflect.New(reflect.SliceOf(sourceType))
+    err := jsonen.Unmarshal(payload, sources.Interface())
+    if err != nil {
+        panic(errors.InternalError("UnableParseSource", "UnableParseSource: "+err.Error()))
+    }
+
+    return sources.Elem().Interface()
 }
 
 func (h *Handler) BatchHandler(ctx context.Context, sources interface{}) (interface{}, error) {
-    panic(errors.InternalError("BatchInvokeNotAllowed", "Batch invoke not allowed"))
+    if !h.batch {
+        panic(errors.InternalError("BatchInvokeNotAllowed", "Batch invoke not allowed"))
+    }
+
+    items := reflect.ValueOf(sources)
+    results := make([]*zamus.Result, items.Len())
+
+    if h.batchConcurrency <= 1 {
+        for i := range results {
+            if zamus.NearDeadline(ctx) {
+                results[i] = zamus.NewResult(nil, zamus.ErrDeadlineNear.New())
+                continue
+            }
+
+            results[i] = h.handleItem(ctx, items.Index(i).Interface())
+        }
+
+        return results, nil
+    }
+
+    var wg sync.WaitGroup
+    sem := make(chan struct{}, h.batchConcurrency)
+    for i := range results {
+        sem <- struct{}{}
+        if zamus.NearDeadline(ctx) {
+            results[i] = zamus.NewResult(nil, zamus.ErrDeadlineNear.New())
+            <-sem
+            continue
+        }
+
+        wg.Add(1)
+        go func(i int) {
+            defer func() {
+                <-sem
+                wg.Done()
+            }()
+
+            results[i] = h.handleItem(ctx, items.Index(i).Interface())
+        }(i)
+    }
+    wg.Wait()
+
+    return results, nil
+}
+
+func (h *Handler) handleItem(ctx context.Context, source interface{}) (result *zamus.Result) {
+    defer func() {
+        if r := recover(); r != nil {
+            result = zamus.NewResult(nil, zamus.PanicError(r))
+        }
+    }()
+
+    if reflect.ValueOf(source).Kind() == reflect.Ptr && reflect.ValueOf(source).IsNil() {
+        return zamus.NewResult(nil, errors.BadRequest("NullSource", "Batch item is null"))
+    }
+
+    return zamus.NewResult(h.Handler(ctx, source))
 }
 
 func (h *Handler) Handler(ctx context.Context, source interface{}) (interface{}, error) {
@@ -4,8 +4,10 @@ import (
     "context"
     "encoding/json"
     "testing"
+    "time"
 
     "github.com/aws/aws-lambda-go/events"
+    "github.com/onedaycat/errors"
     "github.com/onedaycat/zamus/zamus"
     "github.com/stretchr/testify/require"
 )
@@ -49,3 +51,78 @@ func TestS3Handler(t *testing.T) {
         }, result)
     })
 }
+
+func TestHandlerBatch(t *testing.T) {
+    sh := func(ctx context.Context, src *events.SNSEvent) (interface{}, error) {
+        switch src.Records[0].SNS.Message {
+        case "fail":
+            return nil, errors.BadRequest("code1", "msg1")
+        case "panic":
+            panic("boom")
+        }
+
+        return src.Records[0].SNS.Message, nil
+    }
+    handler := NewSNSHandler(sh)
+    h := zamus.New(handler)
+    payload := []byte(`[
+        {"Records":[{"Sns":{"Message":"a"}}]},
+        {"Records":[{"Sns":{"Message":"fail"}}]},
+        {"Records":[{"Sns":{"Message":"panic"}}]},
+        null,
+        {"Records":[{"Sns":{"Message":"b"}}]}
+    ]`)
+    expected := []*zamus.Result{
+        {Data: "a"},
+        {Error: &errors.JSONError{Code: "code1", Message: "msg1", ErrType: errors.BadRequestType}},
+        {Error: &errors.JSONError{Code: "string", Message: "boom", ErrType: errors.InternalErrorType}},
+        {Error: &errors.JSONError{Code: "NullSource", Message: "Batch item is null", ErrType: errors.BadRequestType}},
+        {Data: "b"},
+    }
+
+    t.Run("Not allowed", func(t *testing.T) {
+        _, err := h.Invoke(context.Background(), payload)
+
+        require.Equal(t, "BatchInvokeNotAllowed: Batch invoke not allowed", err.Error())
+    })
+
+    for _, concurrency := range []int{0, 3} {
+        handler.SetBatch(true)
+        handler.SetBatchConcurrency(concurrency)
+
+        result, err := h.Invoke(context.Background(), payload)
+
+        require.NoError(t, err)
+        require.Equal(t, expected, result)
+    }
+
+    t.Run("JSON", func(t *testing.T) {
+        jh := NewJSONHandler(func(ctx context.Context, src json.RawMessage) (interface{}, error) {
+            return string(src), nil
+        })
+        jh.SetBatch(true)
+
+        result, err := zamus.New(jh).Invoke(context.Background(), []byte(`[{"id":"1"},2]`))
+
+        require.NoError(t, err)
+        require.Equal(t, []*zamus.Result{{Data: `{"id":"1"}`}, {Data: "2"}}, result)
+    })
+
+    t.Run("Deadline", func(t *testing.T) {
+        jh := NewJSONHandler(func(ctx context.Context, src json.RawMessage) (interface{}, error) {
+            time.Sleep(30 * time.Millisecond)
+            return string(src), nil
+        })
+        jh.SetBatch(true)
+        h := zamus.New(jh)
+        h.SetDeadlineThreshold(time.Second - 20*time.Millisecond)
+        ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+        defer cancel()
+
+        result, err := h.Invoke(ctx, []byte(`[1,2,3]`))
+
+        require.NoError(t, err)
+        unstarted := &zamus.Result{Error: zamus.NewResultError(zamus.ErrDeadlineNear.New())}
+        require.Equal(t, []*zamus.Result{{Data: "1"}, unstarted, unstarted}, result)
+    })
+}
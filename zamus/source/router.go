@@ -0,0 +1,157 @@
+package source
+
+import (
+    "context"
+    "encoding/json"
+    "reflect"
+    "strings"
+
+    "github.com/onedaycat/errors"
+    "github.com/onedaycat/zamus/zamus"
+)
+
+// Router picks the registered Handler matching the shape of the payload, so
+// one function can be wired to several event sources at once.
+type Router struct {
+    handlers map[reflect.Type]*Handler
+    fallback zamus.Handler
+}
+
+func NewRouter(handlers ...*Handler) *Router {
+    r := &Router{
+        handlers: make(map[reflect.Type]*Handler),
+    }
+    r.Register(handlers...)
+
+    return r
+}
+
+// Register adds handlers keyed by their event type. A JSON handler accepts
+// any payload and becomes the fallback.
+func (r *Router) Register(handlers ...*Handler) {
+    for _, h := range handlers {
+        if h.source == nil {
+            r.fallback = h
+            continue
+        }
+
+        r.handlers[reflect.TypeOf(h.source())] = h
+    }
+}
+
+// SetFallback handles payloads of unknown shape or without a registered
+// handler.
+func (r *Router) SetFallback(fallback zamus.Handler) {
+    r.fallback = fallback
+}
+
+func (r *Router) ParseSource(ctx context.Context, payload json.RawMessage) interface{} {
+    source := detect(payload)
+    if source != nil {
+        if h, ok := r.handlers[reflect.TypeOf(source())]; ok {
+            return h.ParseSource(ctx, payload)
+        }
+    }
+
+    if r.fallback != nil {
+        return r.fallback.ParseSource(ctx, payload)
+    }
+
+    if source == nil {
+        panic(errors.BadRequest("UnknownEventSource", "Unable to detect event source"))
+    }
+
+    panic(errors.BadRequest("UnknownEventSource", "No handler for "+reflect.TypeOf(source()).Elem().Name()))
+}
+
+func (r *Router) ParseSources(ctx context.Context, payload json.RawMessage) interface{} {
+    if r.fallback != nil {
+        return r.fallback.ParseSources(ctx, payload)
+    }
+
+    panic(errors.InternalError("BatchInvokeNotAllowed", "Batch invoke not allowed"))
+}
+
+func (r *Router) Handler(ctx context.Context, source interface{}) (interface{}, error) {
+    if h, ok := r.handlers[reflect.TypeOf(source)]; ok {
+        return h.Handler(ctx, source)
+    }
+
+    if r.fallback == nil {
+        return nil, errors.BadRequest("UnknownEventSource", "No handler for "+reflect.TypeOf(source).String())
+    }
+
+    return r.fallback.Handler(ctx, source)
+}
+
+func (r *Router) BatchHandler(ctx context.Context, sources interface{}) (interface{}, error) {
+    if r.fallback == nil {
+        panic(errors.InternalError("BatchInvokeNotAllowed", "Batch invoke not allowed"))
+    }
+
+    return r.fallback.BatchHandler(ctx, sources)
+}
+
+func detect(payload json.RawMessage) newSrouce {
+    if records := jsonen.Get(payload, "Records", 0); records.LastError() == nil {
+        switch records.Get("eventSource").ToString() {
+        case "aws:sqs":
+            return newSQS
+        case "aws:s3":
+            return newS3
+        case "aws:kinesis":
+            return newKinesis
+        case "aws:dynamodb":
+            return newDynamoDBStream
+        }
+
+        if records.Get("EventSource").ToString() == "aws:sns" {
+            return newSNS
+        }
+
+        return nil
+    }
+
+    if has(payload, "deliveryStreamArn") {
+        return newFirehose
+    }
+
+    if has(payload, "awslogs") {
+        return newCloudwatchLogsEvent
+    }
+
+    if has(payload, "detail-type") {
+        return newCloudWatchEvent
+    }
+
+    if trigger := jsonen.Get(payload, "triggerSource"); trigger.LastError() == nil {
+        switch name := trigger.ToString(); {
+        case strings.HasPrefix(name, "PreSignUp_"):
+            return newCognitoPreSignUp
+        case strings.HasPrefix(name, "PostConfirmation_"):
+            return newCognitoPostConfirm
+        case strings.HasPrefix(name, "TokenGeneration_"):
+            return newCognitoPreToken
+        }
+
+        return nil
+    }
+
+    if has(payload, "methodArn") {
+        return newAPIGatewayCustomAuthorizerRequest
+    }
+
+    if has(payload, "requestContext") && has(payload, "httpMethod") {
+        return newAPIGatewayProxyRequest
+    }
+
+    if has(payload, "currentIntent") {
+        return newLexEvent
+    }
+
+    return nil
+}
+
+func has(payload json.RawMessage, key string) bool {
+    return jsonen.Get(payload, key).LastError() == nil
+}
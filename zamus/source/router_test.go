@@ -0,0 +1,100 @@
+package source
+
+import (
+    "context"
+    "encoding/json"
+    "testing"
+
+    "github.com/aws/aws-lambda-go/events"
+    "github.com/onedaycat/zamus/zamus"
+    "github.com/stretchr/testify/require"
+)
+
+func TestRouter(t *testing.T) {
+    r := NewRouter(
+        NewSQSHandler(func(ctx context.Context, src *events.SQSEvent) (interface{}, error) {
+            return "sqs:" + src.Records[0].Body, nil
+        }),
+        NewSNSHandler(func(ctx context.Context, src *events.SNSEvent) (interface{}, error) {
+            return "sns:" + src.Records[0].SNS.Message, nil
+        }),
+        NewS3EventHandler(func(ctx context.Context, src *events.S3Event) (interface{}, error) {
+            return "s3:" + src.Records[0].S3.Bucket.Name, nil
+        }),
+        NewCloudWatchEventHandler(func(ctx context.Context, src *events.CloudWatchEvent) (interface{}, error) {
+            return "eventbridge:" + src.DetailType, nil
+        }),
+        NewAPIGatewayProxyRequestHandler(func(ctx context.Context, src *events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error) {
+            return &events.APIGatewayProxyResponse{Body: "apigw:" + src.Path}, nil
+        }),
+        NewCognitoPreSignUpHandler(func(ctx context.Context, src *events.CognitoEventUserPoolsPreSignup) *events.CognitoEventUserPoolsPreSignup {
+            src.Response.AutoConfirmUser = true
+            return src
+        }),
+    )
+    h := zamus.New(r)
+    ctx := context.Background()
+
+    tests := []struct {
+        name    string
+        payload string
+        result  interface{}
+    }{
+        {"SQS", `{"Records":[{"eventSource":"aws:sqs","body":"1"}]}`, "sqs:1"},
+        {"SNS", `{"Records":[{"EventSource":"aws:sns","Sns":{"Message":"2"}}]}`, "sns:2"},
+        {"S3", `{"Records":[{"eventSource":"aws:s3","s3":{"bucket":{"name":"b"}}}]}`, "s3:b"},
+        {"EventBridge", `{"detail-type":"OrderCreated","detail":{}}`, "eventbridge:OrderCreated"},
+        {"APIGateway", `{"httpMethod":"GET","path":"/a","requestContext":{}}`, &events.APIGatewayProxyResponse{Body: "apigw:/a"}},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            result, err := h.Invoke(ctx, []byte(tt.payload))
+
+            require.NoError(t, err)
+            require.Equal(t, tt.result, result)
+        })
+    }
+
+    t.Run("Cognito", func(t *testing.T) {
+        result, err := h.Invoke(ctx, []byte(`{"triggerSource":"PreSignUp_SignUp"}`))
+
+        require.NoError(t, err)
+        require.True(t, result.(*events.CognitoEventUserPoolsPreSignup).Response.AutoConfirmUser)
+    })
+
+    t.Run("Unknown shape", func(t *testing.T) {
+        result, err := h.Invoke(ctx, []byte(`{"id":"1"}`))
+
+        require.Equal(t, "UnknownEventSource: Unable to detect event source", err.Error())
+        require.Nil(t, result)
+    })
+
+    t.Run("Unregistered source", func(t *testing.T) {
+        result, err := h.Invoke(ctx, []byte(`{"Records":[{"eventSource":"aws:kinesis"}]}`))
+
+        require.Equal(t, "UnknownEventSource: No handler for KinesisEvent", err.Error())
+        require.Nil(t, result)
+    })
+}
+
+func TestRouterFallback(t *testing.T) {
+    r := NewRouter(
+        NewSQSHandler(func(ctx context.Context, src *events.SQSEvent) (interface{}, error) {
+            return "sqs", nil
+        }),
+        NewJSONHandler(func(ctx context.Context, src json.RawMessage) (interface{}, error) {
+            return "json:" + string(src), nil
+        }),
+    )
+    h := zamus.New(r)
+    ctx := context.Background()
+
+    result, err := h.Invoke(ctx, []byte(`{"id":"1"}`))
+    require.NoError(t, err)
+    require.Equal(t, `json:{"id":"1"}`, result)
+
+    result, err = h.Invoke(ctx, []byte(`{"Records":[{"eventSource":"aws:sqs"}]}`))
+    require.NoError(t, err)
+    require.Equal(t, "sqs", result)
+}
@@ -0,0 +1,244 @@
+package tenant
+
+import (
+    "context"
+    "encoding/json"
+    "strconv"
+    "strings"
+    "sync"
+
+    "github.com/aws/aws-lambda-go/events"
+    "github.com/onedaycat/errors"
+    "github.com/onedaycat/zamus/tracer"
+    "github.com/onedaycat/zamus/zamus"
+    "github.com/onedaycat/zamus/zamus/ratelimit"
+    "github.com/onedaycat/zamus/zamus/record"
+)
+
+// Key is the attribute and trace tag holding the tenant ID.
+const Key = "tenant"
+
+var (
+    ErrMissingTenant = errors.DefUnauthorized("MissingTenant", "Unable to resolve tenant")
+    // ErrMixedTenants is returned by Resolve for a batch whose records do not
+    // all belong to the same tenant. Middleware lets such a batch through.
+    ErrMixedTenants = errors.DefBadRequest("MixedTenants", "Records belong to different tenants")
+)
+
+type tenantKey struct{}
+
+// FromContext returns the tenant resolved for the invocation, or an empty
+// string.
+func FromContext(ctx context.Context) string {
+    id, _ := ctx.Value(tenantKey{}).(string)
+    return id
+}
+
+// Extractor reads the tenant ID from a decoded payload. It returns an
+// error when the payload holds conflicting tenants.
+type Extractor func(event map[string]interface{}) (string, error)
+
+// Resolver rejects invocations without a tenant and scopes the others to
+// their tenant.
+type Resolver struct {
+    extractors   []Extractor
+    tracer       tracer.Tracer
+    newRateLimit func(tenantID string) *ratelimit.RateLimit
+    mu           sync.Mutex
+    rateLimits   map[string]*ratelimit.RateLimit
+}
+
+// New tries the extractors in order and keeps the first tenant found.
+func New(extractors ...Extractor) *Resolver {
+    return &Resolver{
+        extractors: extractors,
+        rateLimits: make(map[string]*ratelimit.RateLimit),
+    }
+}
+
+// SetTracer tags the trace with the tenant under "tenant".
+func (r *Resolver) SetTracer(t tracer.Tracer) {
+    r.tracer = t
+}
+
+// SetRateLimit limits each tenant on its own. newRateLimit is called once
+// per tenant seen by the execution environment; use a distributed limiter
+// keyed by the tenant to share the limit between environments.
+func (r *Resolver) SetRateLimit(newRateLimit func(tenantID string) *ratelimit.RateLimit) {
+    r.newRateLimit = newRateLimit
+}
+
+// Middleware stores the tenant on the context passed to the handlers and in
+// zamus.Attrs under Key, where outer middlewares such as the request logger
+// read it. A batch mixing tenants has no tenant of its own and is passed on
+// as is, for SQSHandler to resolve and limit each record.
+func (r *Resolver) Middleware(next zamus.InvokeHandler) zamus.InvokeHandler {
+    return func(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+        id, err := r.Resolve(payload)
+        if xerr, ok := err.(errors.Error); ok && ErrMixedTenants.Is(xerr) {
+            return next(ctx, payload)
+        }
+        if err != nil {
+            return nil, err
+        }
+
+        zamus.Attrs(ctx).Set(Key, id)
+        if r.tracer != nil {
+            r.tracer.SetTag(ctx, Key, id)
+        }
+
+        if err = r.take(ctx, id); err != nil {
+            return nil, err
+        }
+
+        return next(context.WithValue(ctx, tenantKey{}, id), payload)
+    }
+}
+
+// SQSHandler resolves the tenant of each message of a batch mixing tenants
+// from the string message attribute, for a record.Processor. A message
+// without tenant or over the rate limit of its tenant fails on its own and
+// is delivered again. Messages of a batch resolved by Middleware are passed
+// on unchanged.
+func (r *Resolver) SQSHandler(attribute string, next record.SQSHandler) record.SQSHandler {
+    return func(ctx context.Context, msg *events.SQSMessage) error {
+        if FromContext(ctx) != "" {
+            return next(ctx, msg)
+        }
+
+        attr, ok := msg.MessageAttributes[attribute]
+        if !ok || attr.StringValue == nil || *attr.StringValue == "" {
+            return ErrMissingTenant.Newf("Message %s has no %s attribute", msg.MessageId, attribute)
+        }
+        id := *attr.StringValue
+
+        if err := r.take(ctx, id); err != nil {
+            return err
+        }
+
+        return next(context.WithValue(ctx, tenantKey{}, id), msg)
+    }
+}
+
+func (r *Resolver) Resolve(payload json.RawMessage) (string, error) {
+    event := make(map[string]interface{})
+    if err := json.Unmarshal(payload, &event); err != nil {
+        return "", ErrMissingTenant.New()
+    }
+
+    for _, extract := range r.extractors {
+        id, err := extract(event)
+        if err != nil {
+            return "", err
+        }
+
+        if id != "" {
+            return id, nil
+        }
+    }
+
+    return "", ErrMissingTenant.New()
+}
+
+func (r *Resolver) take(ctx context.Context, id string) error {
+    if r.newRateLimit == nil {
+        return nil
+    }
+
+    r.mu.Lock()
+    rl, ok := r.rateLimits[id]
+    if !ok {
+        rl = r.newRateLimit(id)
+        r.rateLimits[id] = rl
+    }
+    r.mu.Unlock()
+
+    return rl.Take(ctx, 1)
+}
+
+// Field reads the field at a dot path such as "detail.tenantId".
+func Field(path string) Extractor {
+    return func(event map[string]interface{}) (string, error) {
+        return lookup(event, path), nil
+    }
+}
+
+// AuthorizerContext reads a key set in the context by an API Gateway Lambda
+// authorizer, for REST and HTTP APIs.
+func AuthorizerContext(key string) Extractor {
+    return first(
+        "requestContext.authorizer."+key,
+        "requestContext.authorizer.lambda."+key,
+    )
+}
+
+// JWTClaim reads a claim of a token verified by API Gateway, from a Cognito
+// authorizer of a REST API or a JWT authorizer of an HTTP API. Tokens are
+// never decoded from the headers, as they would be unverified.
+func JWTClaim(claim string) Extractor {
+    return first(
+        "requestContext.authorizer.claims."+claim,
+        "requestContext.authorizer.jwt.claims."+claim,
+    )
+}
+
+// DetailField reads a field of the detail of an EventBridge event.
+func DetailField(path string) Extractor {
+    return Field("detail." + path)
+}
+
+// MessageAttribute reads a string message attribute of SQS or SNS records.
+// It returns ErrMixedTenants when some records carry another tenant or none.
+func MessageAttribute(name string) Extractor {
+    return func(event map[string]interface{}) (string, error) {
+        records, _ := event["Records"].([]interface{})
+
+        var id string
+        for i, item := range records {
+            m, _ := item.(map[string]interface{})
+            recordID := lookup(m, "messageAttributes."+name+".stringValue")
+            if recordID == "" {
+                recordID = lookup(m, "Sns.MessageAttributes."+name+".Value")
+            }
+
+            if i > 0 && recordID != id {
+                return "", ErrMixedTenants.Newf("Records belong to tenants %q and %q", id, recordID)
+            }
+            id = recordID
+        }
+
+        return id, nil
+    }
+}
+
+func first(paths ...string) Extractor {
+    return func(event map[string]interface{}) (string, error) {
+        for _, path := range paths {
+            if id := lookup(event, path); id != "" {
+                return id, nil
+            }
+        }
+
+        return "", nil
+    }
+}
+
+func lookup(event map[string]interface{}, path string) string {
+    var value interface{} = event
+    for _, key := range strings.Split(path, ".") {
+        m, ok := value.(map[string]interface{})
+        if !ok {
+            return ""
+        }
+        value = m[key]
+    }
+
+    switch v := value.(type) {
+    case string:
+        return v
+    case float64:
+        return strconv.FormatFloat(v, 'f', -1, 64)
+    }
+
+    return ""
+}
@@ -0,0 +1,137 @@
+package tenant
+
+import (
+    "context"
+    "sync"
+    "testing"
+
+    "github.com/aws/aws-lambda-go/events"
+    "github.com/onedaycat/errors"
+    "github.com/onedaycat/zamus/zamus"
+    "github.com/onedaycat/zamus/zamus/ratelimit"
+    "github.com/onedaycat/zamus/zamus/record"
+    "github.com/onedaycat/zamus/zamus/zamustest"
+    "github.com/stretchr/testify/require"
+)
+
+func TestResolver(t *testing.T) {
+    r := New(
+        AuthorizerContext("tenantId"),
+        JWTClaim("custom:tenant"),
+        MessageAttribute("tenant"),
+        DetailField("tenant.id"),
+    )
+
+    tests := []struct {
+        name    string
+        payload string
+        tenant  string
+        err     *errors.ErrorDefinition
+    }{
+        {"REST authorizer", `{"requestContext":{"authorizer":{"tenantId":"t1"}}}`, "t1", nil},
+        {"HTTP authorizer", `{"requestContext":{"authorizer":{"lambda":{"tenantId":"t1"}}}}`, "t1", nil},
+        {"Cognito claim", `{"requestContext":{"authorizer":{"claims":{"custom:tenant":"t2"}}}}`, "t2", nil},
+        {"JWT claim", `{"requestContext":{"authorizer":{"jwt":{"claims":{"custom:tenant":"t2"}}}}}`, "t2", nil},
+        {"SQS", `{"Records":[{"messageAttributes":{"tenant":{"stringValue":"t3"}}},{"messageAttributes":{"tenant":{"stringValue":"t3"}}}]}`, "t3", nil},
+        {"SNS", `{"Records":[{"Sns":{"MessageAttributes":{"tenant":{"Value":"t3"}}}}]}`, "t3", nil},
+        {"Mixed tenants", `{"Records":[{"messageAttributes":{"tenant":{"stringValue":"t3"}}},{"messageAttributes":{"tenant":{"stringValue":"t4"}}}]}`, "", ErrMixedTenants},
+        {"Record without tenant", `{"Records":[{"messageAttributes":{"tenant":{"stringValue":"t3"}}},{"messageAttributes":{}}]}`, "", ErrMixedTenants},
+        {"EventBridge", `{"detail-type":"created","detail":{"tenant":{"id":42}}}`, "42", nil},
+        {"Missing", `{"id":"1"}`, "", ErrMissingTenant},
+        {"Not an object", `[1]`, "", ErrMissingTenant},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            id, err := r.Resolve([]byte(tt.payload))
+            if tt.err != nil {
+                require.True(t, tt.err.Is(err.(errors.Error)))
+                return
+            }
+
+            require.NoError(t, err)
+            require.Equal(t, tt.tenant, id)
+        })
+    }
+}
+
+func TestResolverMiddleware(t *testing.T) {
+    var tenant, attr string
+    th := &zamustest.Handler{
+        HandlerFunc: func(ctx context.Context, source interface{}) (interface{}, error) {
+            tenant = FromContext(ctx)
+            attr = zamus.Attrs(ctx).String(Key)
+            return nil, nil
+        },
+    }
+    r := New(Field("tenant"))
+    limits := make(map[string]int)
+    r.SetRateLimit(func(tenantID string) *ratelimit.RateLimit {
+        limits[tenantID]++
+        return ratelimit.New(ratelimit.NewTokenBucket(0.001, 1))
+    })
+    h := zamus.New(th)
+    h.Use(r.Middleware)
+    ctx := context.Background()
+
+    _, err := h.Invoke(ctx, []byte(`{"tenant":"t1"}`))
+    require.NoError(t, err)
+    require.Equal(t, "t1", tenant)
+    require.Equal(t, "t1", attr)
+
+    _, err = h.Invoke(ctx, []byte(`{"tenant":"t2"}`))
+    require.NoError(t, err)
+
+    _, err = h.Invoke(ctx, []byte(`{"tenant":"t1"}`))
+    require.True(t, ratelimit.ErrThrottled.Is(err.(errors.Error)))
+    require.Equal(t, map[string]int{"t1": 1, "t2": 1}, limits)
+
+    tenant = ""
+    _, err = h.Invoke(ctx, []byte(`{"id":"1"}`))
+    require.True(t, ErrMissingTenant.Is(err.(errors.Error)))
+    require.Empty(t, tenant)
+}
+
+func TestResolverMixedBatch(t *testing.T) {
+    var mu sync.Mutex
+    tenants := make(map[string]string)
+    r := New(MessageAttribute("tenant"))
+    r.SetRateLimit(func(tenantID string) *ratelimit.RateLimit {
+        return ratelimit.New(ratelimit.NewTokenBucket(0.001, 1))
+    })
+    p := record.NewSQS(r.SQSHandler("tenant", func(ctx context.Context, msg *events.SQSMessage) error {
+        mu.Lock()
+        tenants[msg.MessageId] = FromContext(ctx)
+        mu.Unlock()
+        return nil
+    }))
+    h := zamus.New(p)
+    h.Use(r.Middleware)
+
+    result, err := h.Invoke(context.Background(), []byte(`{"Records":[
+        {"messageId":"1","messageAttributes":{"tenant":{"stringValue":"t1"}}},
+        {"messageId":"2","messageAttributes":{"tenant":{"stringValue":"t2"}}},
+        {"messageId":"3","messageAttributes":{}},
+        {"messageId":"4","messageAttributes":{"tenant":{"stringValue":"t1"}}}
+    ]}`))
+
+    require.NoError(t, err)
+    require.Equal(t, &record.Response{BatchItemFailures: []*record.BatchItemFailure{
+        {ItemIdentifier: "3"},
+        {ItemIdentifier: "4"},
+    }}, result)
+    require.Equal(t, map[string]string{"1": "t1", "2": "t2"}, tenants)
+
+    t.Run("Single tenant", func(t *testing.T) {
+        tenants = make(map[string]string)
+
+        result, err := h.Invoke(context.Background(), []byte(`{"Records":[
+            {"messageId":"5","messageAttributes":{"tenant":{"stringValue":"t3"}}},
+            {"messageId":"6","messageAttributes":{"tenant":{"stringValue":"t3"}}}
+        ]}`))
+
+        require.NoError(t, err)
+        require.Empty(t, result.(*record.Response).BatchItemFailures)
+        require.Equal(t, map[string]string{"5": "t3", "6": "t3"}, tenants)
+    })
+}
@@ -0,0 +1,294 @@
+package upcast
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "strconv"
+
+    "github.com/onedaycat/errors"
+    "github.com/onedaycat/zamus/zamus"
+)
+
+var ErrUpcastFailed = errors.DefInternalError("UpcastFailed", "Unable to upcast event")
+
+// Upcaster transforms an event of one version into the next version. It
+// does not need to update the version field.
+type Upcaster func(event json.RawMessage) (json.RawMessage, error)
+
+// Registry upcasts events to their latest version. Events are identified by
+// their type and version fields; an event without a version is version 1.
+type Registry struct {
+    upcasters    map[string]map[int]Upcaster
+    typeField    string
+    versionField string
+}
+
+func New() *Registry {
+    return &Registry{
+        upcasters:    make(map[string]map[int]Upcaster),
+        typeField:    "type",
+        versionField: "version",
+    }
+}
+
+// SetFields sets the names of the top-level type and version fields. The
+// type of an EventBridge event is always its detail-type.
+func (r *Registry) SetFields(typeField, versionField string) {
+    r.typeField = typeField
+    r.versionField = versionField
+}
+
+// Register adds the upcaster from version to version+1 of eventType.
+func (r *Registry) Register(eventType string, version int, upcaster Upcaster) {
+    versions, ok := r.upcasters[eventType]
+    if !ok {
+        versions = make(map[int]Upcaster)
+        r.upcasters[eventType] = versions
+    }
+
+    versions[version] = upcaster
+}
+
+// Middleware upcasts the payload before it is parsed. The bodies of SQS
+// records, the messages of SNS records, the detail of EventBridge events,
+// the items of a batch and plain JSON events are upcast.
+func (r *Registry) Middleware(next zamus.InvokeHandler) zamus.InvokeHandler {
+    return func(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+        upcasted, err := r.UpcastPayload(payload)
+        if err != nil {
+            return nil, err
+        }
+
+        return next(ctx, upcasted)
+    }
+}
+
+// UpcastPayload returns payload itself, not encoded again, when no event in
+// it is upcast.
+func (r *Registry) UpcastPayload(payload json.RawMessage) (json.RawMessage, error) {
+    var items []json.RawMessage
+    if err := json.Unmarshal(payload, &items); err == nil {
+        changed := false
+        for i, item := range items {
+            upcasted, err := r.upcastEnvelope(item)
+            if err != nil {
+                return nil, err
+            }
+            if !bytes.Equal(upcasted, item) {
+                items[i] = upcasted
+                changed = true
+            }
+        }
+        if !changed {
+            return payload, nil
+        }
+
+        return json.Marshal(items)
+    }
+
+    return r.upcastEnvelope(payload)
+}
+
+func (r *Registry) upcastEnvelope(payload json.RawMessage) (json.RawMessage, error) {
+    event := make(map[string]json.RawMessage)
+    if err := json.Unmarshal(payload, &event); err != nil {
+        return payload, nil
+    }
+
+    if raw, ok := event["Records"]; ok {
+        var records []map[string]json.RawMessage
+        if err := json.Unmarshal(raw, &records); err != nil {
+            return payload, nil
+        }
+
+        changed := false
+        for _, record := range records {
+            upcasted, err := r.upcastRecord(record)
+            if err != nil {
+                return nil, err
+            }
+            changed = changed || upcasted
+        }
+        if !changed {
+            return payload, nil
+        }
+
+        return marshalField(event, "Records", records)
+    }
+
+    if raw, ok := event["detail-type"]; ok {
+        var eventType string
+        if err := json.Unmarshal(raw, &eventType); err != nil || len(event["detail"]) == 0 {
+            return payload, nil
+        }
+
+        detail, err := r.upcast(eventType, event["detail"])
+        if err != nil {
+            return nil, err
+        }
+        if bytes.Equal(detail, event["detail"]) {
+            return payload, nil
+        }
+        event["detail"] = detail
+
+        return json.Marshal(event)
+    }
+
+    return r.Upcast(payload)
+}
+
+// upcastRecord upcasts the JSON body of an SQS record or the JSON message of
+// an SNS record in place, and reports whether it did.
+func (r *Registry) upcastRecord(record map[string]json.RawMessage) (bool, error) {
+    if raw, ok := record["body"]; ok {
+        body, err := r.upcastString(raw)
+        if err != nil || bytes.Equal(body, raw) {
+            return false, err
+        }
+        record["body"] = body
+
+        return true, nil
+    }
+
+    raw, ok := record["Sns"]
+    if !ok {
+        return false, nil
+    }
+
+    sns := make(map[string]json.RawMessage)
+    if err := json.Unmarshal(raw, &sns); err != nil {
+        return false, nil
+    }
+
+    message, err := r.upcastString(sns["Message"])
+    if err != nil || bytes.Equal(message, sns["Message"]) {
+        return false, err
+    }
+    sns["Message"] = message
+
+    raw, err = json.Marshal(sns)
+    if err != nil {
+        return false, err
+    }
+    record["Sns"] = raw
+
+    return true, nil
+}
+
+func (r *Registry) upcastString(raw json.RawMessage) (json.RawMessage, error) {
+    var s string
+    if err := json.Unmarshal(raw, &s); err != nil {
+        return raw, nil
+    }
+
+    upcasted, err := r.Upcast(json.RawMessage(s))
+    if err != nil {
+        return nil, err
+    }
+    if string(upcasted) == s {
+        return raw, nil
+    }
+
+    return json.Marshal(string(upcasted))
+}
+
+// Upcast upcasts a plain JSON event carrying its own type and version
+// fields. Unknown events are returned unchanged.
+func (r *Registry) Upcast(event json.RawMessage) (json.RawMessage, error) {
+    fields := make(map[string]json.RawMessage)
+    if err := json.Unmarshal(event, &fields); err != nil {
+        return event, nil
+    }
+
+    var eventType string
+    if err := json.Unmarshal(fields[r.typeField], &eventType); err != nil {
+        return event, nil
+    }
+
+    return r.upcast(eventType, event)
+}
+
+func (r *Registry) upcast(eventType string, event json.RawMessage) (json.RawMessage, error) {
+    versions, ok := r.upcasters[eventType]
+    if !ok {
+        return event, nil
+    }
+
+    fields := make(map[string]json.RawMessage)
+    if err := json.Unmarshal(event, &fields); err != nil {
+        return event, nil
+    }
+
+    // A version given as a string stays a string.
+    quoted := isString(fields[r.versionField])
+    version, err := parseVersion(fields[r.versionField])
+    if err != nil {
+        return nil, ErrUpcastFailed.Newf("Invalid version of %s: %s", eventType, err.Error())
+    }
+
+    for {
+        upcaster, ok := versions[version]
+        if !ok {
+            return event, nil
+        }
+
+        event, err = upcaster(event)
+        if err != nil {
+            return nil, ErrUpcastFailed.Newf("Unable to upcast %s from version %d", eventType, version).WithCause(err)
+        }
+        version++
+
+        fields = make(map[string]json.RawMessage)
+        if err = json.Unmarshal(event, &fields); err != nil {
+            return nil, ErrUpcastFailed.Newf("Upcaster of %s version %d returned invalid JSON", eventType, version-1).WithCause(err)
+        }
+
+        var value interface{} = version
+        if quoted {
+            value = strconv.Itoa(version)
+        }
+        if event, err = marshalField(fields, r.versionField, value); err != nil {
+            return nil, err
+        }
+    }
+}
+
+// parseVersion accepts numbers and numeric strings.
+func parseVersion(raw json.RawMessage) (int, error) {
+    if len(raw) == 0 {
+        return 1, nil
+    }
+
+    var version interface{}
+    if err := json.Unmarshal(raw, &version); err != nil {
+        return 0, err
+    }
+
+    switch v := version.(type) {
+    case nil:
+        return 1, nil
+    case float64:
+        return int(v), nil
+    case string:
+        return strconv.Atoi(v)
+    }
+
+    return 0, fmt.Errorf("version must be a number, got %s", raw)
+}
+
+func isString(raw json.RawMessage) bool {
+    raw = bytes.TrimSpace(raw)
+    return len(raw) > 0 && raw[0] == '"'
+}
+
+func marshalField(fields map[string]json.RawMessage, name string, value interface{}) (json.RawMessage, error) {
+    raw, err := json.Marshal(value)
+    if err != nil {
+        return nil, err
+    }
+    fields[name] = raw
+
+    return json.Marshal(fields)
+}
@@ -0,0 +1,181 @@
+package upcast
+
+import (
+    "context"
+    "encoding/json"
+    "testing"
+
+    "github.com/aws/aws-lambda-go/events"
+    "github.com/onedaycat/errors"
+    "github.com/onedaycat/zamus/zamus"
+    "github.com/onedaycat/zamus/zamus/source"
+    "github.com/stretchr/testify/require"
+)
+
+type orderPlaced struct {
+    Type     string `json:"type"`
+    Version  int    `json:"version"`
+    Customer struct {
+        ID string `json:"id"`
+    } `json:"customer"`
+    Total float64 `json:"total"`
+}
+
+func newRegistry() *Registry {
+    r := New()
+    // v1 had customerId, v2 nests the customer.
+    r.Register("OrderPlaced", 1, func(event json.RawMessage) (json.RawMessage, error) {
+        v1 := make(map[string]interface{})
+        _ = json.Unmarshal(event, &v1)
+        v1["customer"] = map[string]interface{}{"id": v1["customerId"]}
+        delete(v1, "customerId")
+        return json.Marshal(v1)
+    })
+    // v2 had the total in cents.
+    r.Register("OrderPlaced", 2, func(event json.RawMessage) (json.RawMessage, error) {
+        v2 := make(map[string]interface{})
+        _ = json.Unmarshal(event, &v2)
+        v2["total"] = v2["total"].(float64) / 100
+        return json.Marshal(v2)
+    })
+
+    return r
+}
+
+func TestUpcast(t *testing.T) {
+    r := newRegistry()
+
+    tests := []struct {
+        name    string
+        event   string
+        version string
+    }{
+        {"Without version", `{"type":"OrderPlaced","customerId":"c1","total":1250}`, `3`},
+        {"Version 1", `{"type":"OrderPlaced","version":1,"customerId":"c1","total":1250}`, `3`},
+        {"String version", `{"type":"OrderPlaced","version":"1","customerId":"c1","total":1250}`, `"3"`},
+        {"Version 2", `{"type":"OrderPlaced","version":2,"customer":{"id":"c1"},"total":1250}`, `3`},
+        {"Latest", `{"type":"OrderPlaced","version":3,"customer":{"id":"c1"},"total":12.5}`, `3`},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            upcasted, err := r.Upcast([]byte(tt.event))
+            require.NoError(t, err)
+
+            fields := make(map[string]json.RawMessage)
+            require.NoError(t, json.Unmarshal(upcasted, &fields))
+            require.Equal(t, tt.version, string(fields["version"]))
+            delete(fields, "version")
+            data, _ := json.Marshal(fields)
+
+            event := &orderPlaced{}
+            require.NoError(t, json.Unmarshal(data, event))
+            require.Equal(t, "c1", event.Customer.ID)
+            require.Equal(t, 12.5, event.Total)
+        })
+    }
+
+    t.Run("Unknown type", func(t *testing.T) {
+        upcasted, err := r.Upcast([]byte(`{"type":"Other","version":1}`))
+
+        require.NoError(t, err)
+        require.Equal(t, `{"type":"Other","version":1}`, string(upcasted))
+    })
+
+    t.Run("Upcaster error", func(t *testing.T) {
+        r.Register("Broken", 1, func(event json.RawMessage) (json.RawMessage, error) {
+            return nil, errors.New("broken")
+        })
+
+        _, err := r.Upcast([]byte(`{"type":"Broken"}`))
+
+        require.True(t, ErrUpcastFailed.Is(err.(errors.Error)))
+    })
+}
+
+func TestUpcastPayloadUnchanged(t *testing.T) {
+    r := newRegistry()
+
+    payloads := []string{
+        `[ {"type":"Other"}, {"type":"OrderPlaced","version":3} ]`,
+        `{"Records":[ {"eventSource":"aws:sqs","body":"{\"type\": \"Other\"}"} ]}`,
+        `{"Records":[ {"EventSource":"aws:sns","Sns":{"Message":"{\"type\":\"Other\"}"}} ]}`,
+        `{"detail-type":"Other", "detail":{"total":1250}}`,
+        `{"type": "OrderPlaced", "version": 3}`,
+    }
+
+    for _, payload := range payloads {
+        upcasted, err := r.UpcastPayload([]byte(payload))
+
+        require.NoError(t, err)
+        require.Equal(t, payload, string(upcasted))
+    }
+}
+
+func TestUpcastMiddleware(t *testing.T) {
+    r := newRegistry()
+
+    t.Run("JSON", func(t *testing.T) {
+        var got *orderPlaced
+        h := zamus.New(source.NewJSONHandler(func(ctx context.Context, src json.RawMessage) (interface{}, error) {
+            got = &orderPlaced{}
+            return nil, json.Unmarshal(src, got)
+        }))
+        h.Use(r.Middleware)
+
+        _, err := h.Invoke(context.Background(), []byte(`{"type":"OrderPlaced","customerId":"c1","total":1250}`))
+
+        require.NoError(t, err)
+        require.Equal(t, "c1", got.Customer.ID)
+    })
+
+    t.Run("SQS", func(t *testing.T) {
+        var bodies []string
+        h := zamus.New(source.NewSQSHandler(func(ctx context.Context, src *events.SQSEvent) (interface{}, error) {
+            for _, record := range src.Records {
+                bodies = append(bodies, record.Body)
+            }
+            return nil, nil
+        }))
+        h.Use(r.Middleware)
+
+        _, err := h.Invoke(context.Background(), []byte(`{"Records":[
+            {"eventSource":"aws:sqs","body":"{\"type\":\"OrderPlaced\",\"customerId\":\"c1\",\"total\":1250}"},
+            {"eventSource":"aws:sqs","body":"not json"}
+        ]}`))
+
+        require.NoError(t, err)
+        require.JSONEq(t, `{"type":"OrderPlaced","version":3,"customer":{"id":"c1"},"total":12.5}`, bodies[0])
+        require.Equal(t, "not json", bodies[1])
+    })
+
+    t.Run("SNS", func(t *testing.T) {
+        var message string
+        h := zamus.New(source.NewSNSHandler(func(ctx context.Context, src *events.SNSEvent) (interface{}, error) {
+            message = src.Records[0].SNS.Message
+            return nil, nil
+        }))
+        h.Use(r.Middleware)
+
+        _, err := h.Invoke(context.Background(), []byte(`{"Records":[
+            {"EventSource":"aws:sns","Sns":{"MessageId":"1","Message":"{\"type\":\"OrderPlaced\",\"version\":2,\"customer\":{\"id\":\"c1\"},\"total\":1250}"}}
+        ]}`))
+
+        require.NoError(t, err)
+        require.JSONEq(t, `{"type":"OrderPlaced","version":3,"customer":{"id":"c1"},"total":12.5}`, message)
+    })
+
+    t.Run("EventBridge", func(t *testing.T) {
+        var detail json.RawMessage
+        h := zamus.New(source.NewCloudWatchEventHandler(func(ctx context.Context, src *events.CloudWatchEvent) (interface{}, error) {
+            detail = src.Detail
+            return nil, nil
+        }))
+        h.Use(r.Middleware)
+
+        _, err := h.Invoke(context.Background(), []byte(`{"detail-type":"OrderPlaced","source":"shop","detail":{"customerId":"c1","total":1250}}`))
+
+        require.NoError(t, err)
+        require.JSONEq(t, `{"version":3,"customer":{"id":"c1"},"total":12.5}`, string(detail))
+    })
+}
@@ -0,0 +1,170 @@
+package warmup
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "os"
+    "reflect"
+    "sync"
+    "time"
+
+    "github.com/aws/aws-lambda-go/lambdacontext"
+    "github.com/aws/aws-sdk-go/aws"
+    "github.com/aws/aws-sdk-go/service/lambda"
+    "github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+    "github.com/onedaycat/zamus/zamus"
+)
+
+// childPayload is sent to the copies started by a fan-out so they do not fan
+// out again.
+var childPayload = []byte(`{"source":"zamus-warmup-child"}`)
+
+// maxPingSize avoids decoding large payloads which cannot be a ping.
+const maxPingSize = 1024
+
+type Response struct {
+    Warm    bool `json:"warm"`
+    Invoked int  `json:"invoked,omitempty"`
+}
+
+// Warmer answers warm-up pings without running the pre-handlers, parsing or
+// the handler.
+type Warmer struct {
+    pings        []map[string]interface{}
+    scheduled    bool
+    client       lambdaiface.LambdaAPI
+    functionName string
+    concurrency  int
+    delay        time.Duration
+}
+
+// New recognizes the payload sent by serverless-plugin-warmup.
+func New() *Warmer {
+    w := &Warmer{
+        delay: 75 * time.Millisecond,
+    }
+    w.AddPing(`{"source":"serverless-plugin-warmup"}`)
+
+    return w
+}
+
+// AddPing recognizes payloads containing every top-level field of the given
+// JSON object with the same value.
+func (w *Warmer) AddPing(shape string) {
+    ping := make(map[string]interface{})
+    if err := json.Unmarshal([]byte(shape), &ping); err != nil {
+        panic("warmup: invalid ping shape: " + err.Error())
+    }
+
+    w.pings = append(w.pings, ping)
+}
+
+// SetScheduledEvent also treats every CloudWatch scheduled event as a ping,
+// for functions whose only schedule is the warmer.
+func (w *Warmer) SetScheduledEvent(scheduled bool) {
+    w.scheduled = scheduled
+}
+
+// SetConcurrency keeps n execution environments warm. On a ping the function
+// invokes itself n-1 times concurrently; each copy waits for the delay so
+// they overlap and land on distinct environments. An empty function name
+// defaults to the invoked ARN, so the alias is kept, then to
+// AWS_LAMBDA_FUNCTION_NAME.
+func (w *Warmer) SetConcurrency(client lambdaiface.LambdaAPI, functionName string, n int) {
+    w.client = client
+    w.functionName = functionName
+    w.concurrency = n
+}
+
+func (w *Warmer) SetDelay(delay time.Duration) {
+    w.delay = delay
+}
+
+func (w *Warmer) Middleware(next zamus.InvokeHandler) zamus.InvokeHandler {
+    return func(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+        if bytes.Equal(payload, childPayload) {
+            time.Sleep(w.delay)
+            return &Response{Warm: true}, nil
+        }
+
+        if !w.IsPing(payload) {
+            return next(ctx, payload)
+        }
+
+        return &Response{Warm: true, Invoked: w.fanOut(ctx)}, nil
+    }
+}
+
+func (w *Warmer) IsPing(payload json.RawMessage) bool {
+    payload = bytes.TrimSpace(payload)
+    if len(payload) == 0 || len(payload) > maxPingSize || payload[0] != '{' {
+        return false
+    }
+
+    event := make(map[string]interface{})
+    if err := json.Unmarshal(payload, &event); err != nil {
+        return false
+    }
+
+    if w.scheduled && event["source"] == "aws.events" && event["detail-type"] == "Scheduled Event" {
+        return true
+    }
+
+    for _, ping := range w.pings {
+        if matches(event, ping) {
+            return true
+        }
+    }
+
+    return false
+}
+
+func matches(event, ping map[string]interface{}) bool {
+    for key, value := range ping {
+        if !reflect.DeepEqual(event[key], value) {
+            return false
+        }
+    }
+
+    return true
+}
+
+func (w *Warmer) fanOut(ctx context.Context) int {
+    if w.client == nil || w.concurrency <= 1 {
+        return 0
+    }
+
+    functionName := w.functionName
+    if lc, ok := lambdacontext.FromContext(ctx); ok && functionName == "" {
+        functionName = lc.InvokedFunctionArn
+    }
+    if functionName == "" {
+        functionName = os.Getenv("AWS_LAMBDA_FUNCTION_NAME")
+    }
+
+    var mu sync.Mutex
+    var wg sync.WaitGroup
+    invoked := 0
+    for i := 1; i < w.concurrency; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            _, err := w.client.InvokeWithContext(ctx, &lambda.InvokeInput{
+                FunctionName:   aws.String(functionName),
+                InvocationType: aws.String(lambda.InvocationTypeRequestResponse),
+                Payload:        childPayload,
+            })
+            if err != nil {
+                return
+            }
+
+            mu.Lock()
+            invoked++
+            mu.Unlock()
+        }()
+    }
+    wg.Wait()
+
+    return invoked
+}
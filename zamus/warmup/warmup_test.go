@@ -0,0 +1,148 @@
+package warmup
+
+import (
+    "context"
+    "encoding/json"
+    "os"
+    "sync"
+    "testing"
+
+    "github.com/aws/aws-lambda-go/lambdacontext"
+    "github.com/aws/aws-sdk-go/aws"
+    "github.com/aws/aws-sdk-go/aws/request"
+    "github.com/aws/aws-sdk-go/service/lambda"
+    "github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+    "github.com/onedaycat/errors"
+    "github.com/onedaycat/zamus/zamus"
+    "github.com/onedaycat/zamus/zamus/zamustest"
+    "github.com/stretchr/testify/require"
+)
+
+type testLambda struct {
+    lambdaiface.LambdaAPI
+    mu     sync.Mutex
+    inputs []*lambda.InvokeInput
+}
+
+func (c *testLambda) InvokeWithContext(ctx aws.Context, input *lambda.InvokeInput, opts ...request.Option) (*lambda.InvokeOutput, error) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    c.inputs = append(c.inputs, input)
+    if len(c.inputs) == 3 {
+        return nil, errors.New("throttled")
+    }
+
+    return &lambda.InvokeOutput{}, nil
+}
+
+func TestWarmer(t *testing.T) {
+    th := &zamustest.Handler{}
+    w := New()
+    w.AddPing(`{"warmer":true,"kind":"ping"}`)
+    h := zamus.New(th)
+    h.RegisterPreHandler(func(ctx context.Context, payload json.RawMessage, src interface{}) (interface{}, error) {
+        return nil, errors.Unauthorized("code1", "msg1")
+    })
+    h.RegisterBatchPreHandler(func(ctx context.Context, payload json.RawMessage, src interface{}) (interface{}, error) {
+        return nil, errors.Unauthorized("code1", "msg1")
+    })
+    h.Use(w.Middleware)
+    ctx := context.Background()
+
+    tests := []struct {
+        name    string
+        payload string
+        warm    bool
+    }{
+        {"serverless-plugin-warmup", `{"source":"serverless-plugin-warmup"}`, true},
+        {"Custom ping", ` {"warmer":true,"kind":"ping","extra":1}`, true},
+        {"Partial ping", `{"warmer":true}`, false},
+        {"Scheduled event disabled", `{"source":"aws.events","detail-type":"Scheduled Event"}`, false},
+        {"Array", `[{"source":"serverless-plugin-warmup"}]`, false},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            result, err := h.Invoke(ctx, []byte(tt.payload))
+
+            if tt.warm {
+                require.NoError(t, err)
+                require.Equal(t, &Response{Warm: true}, result)
+            } else {
+                require.Equal(t, "code1: msg1", err.Error())
+            }
+            require.Equal(t, 0, th.Calls())
+        })
+    }
+
+    t.Run("Scheduled event enabled", func(t *testing.T) {
+        w.SetScheduledEvent(true)
+
+        result, err := h.Invoke(ctx, []byte(`{"source":"aws.events","detail-type":"Scheduled Event"}`))
+
+        require.NoError(t, err)
+        require.Equal(t, &Response{Warm: true}, result)
+    })
+}
+
+func TestWarmerConcurrency(t *testing.T) {
+    client := &testLambda{}
+    w := New()
+    w.SetDelay(0)
+    w.SetConcurrency(client, "", 4)
+    h := zamus.New(&zamustest.Handler{})
+    h.Use(w.Middleware)
+
+    ctx := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{
+        InvokedFunctionArn: "arn:aws:lambda:us-east-1:1:function:orders:live",
+    })
+    result, err := h.Invoke(ctx, []byte(`{"source":"serverless-plugin-warmup"}`))
+
+    require.NoError(t, err)
+    require.Equal(t, &Response{Warm: true, Invoked: 2}, result)
+    require.Len(t, client.inputs, 3)
+    for _, input := range client.inputs {
+        require.Equal(t, "arn:aws:lambda:us-east-1:1:function:orders:live", *input.FunctionName)
+        require.Equal(t, childPayload, input.Payload)
+    }
+
+    t.Run("Function name", func(t *testing.T) {
+        _ = os.Setenv("AWS_LAMBDA_FUNCTION_NAME", "env")
+        defer os.Unsetenv("AWS_LAMBDA_FUNCTION_NAME")
+
+        tests := []struct {
+            name         string
+            functionName string
+            ctx          context.Context
+            expected     string
+        }{
+            {"Explicit", "orders:canary", ctx, "orders:canary"},
+            {"Invoked ARN", "", ctx, "arn:aws:lambda:us-east-1:1:function:orders:live"},
+            {"Environment", "", context.Background(), "env"},
+        }
+
+        for _, tt := range tests {
+            t.Run(tt.name, func(t *testing.T) {
+                client.inputs = nil
+                w.SetConcurrency(client, tt.functionName, 2)
+
+                _, err := h.Invoke(tt.ctx, []byte(`{"source":"serverless-plugin-warmup"}`))
+
+                require.NoError(t, err)
+                require.Len(t, client.inputs, 1)
+                require.Equal(t, tt.expected, *client.inputs[0].FunctionName)
+            })
+        }
+    })
+
+    t.Run("Child does not fan out", func(t *testing.T) {
+        client.inputs = nil
+
+        result, err := h.Invoke(context.Background(), childPayload)
+
+        require.NoError(t, err)
+        require.Equal(t, &Response{Warm: true}, result)
+        require.Empty(t, client.inputs)
+    })
+}
@@ -0,0 +1,169 @@
+package workflow
+
+import (
+    "context"
+    "encoding/json"
+    "io/ioutil"
+    "net/url"
+    "os"
+    "path/filepath"
+    "sync"
+)
+
+// Store persists executions. Load returns nil for an unknown execution.
+// Save only writes when the stored execution still has execution.Version,
+// zero when there is none, and then increments execution.Version; otherwise
+// it returns ErrConflict. With DynamoDB this is a condition on the version
+// attribute.
+type Store interface {
+    Load(ctx context.Context, executionID string) (*Execution, error)
+    Save(ctx context.Context, execution *Execution) error
+}
+
+type memoryStore struct {
+    mu         sync.Mutex
+    executions map[string][]byte
+    versions   map[string]int64
+}
+
+// NewMemoryStore keeps executions in the process, for tests.
+func NewMemoryStore() Store {
+    return &memoryStore{
+        executions: make(map[string][]byte),
+        versions:   make(map[string]int64),
+    }
+}
+
+func (s *memoryStore) Load(ctx context.Context, executionID string) (*Execution, error) {
+    s.mu.Lock()
+    data, ok := s.executions[executionID]
+    s.mu.Unlock()
+
+    if !ok {
+        return nil, nil
+    }
+
+    execution := &Execution{}
+    if err := json.Unmarshal(data, execution); err != nil {
+        return nil, err
+    }
+
+    return execution, nil
+}
+
+func (s *memoryStore) Save(ctx context.Context, execution *Execution) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if s.versions[execution.ID] != execution.Version {
+        return conflict(execution)
+    }
+
+    data, err := marshalNext(execution)
+    if err != nil {
+        return err
+    }
+
+    s.executions[execution.ID] = data
+    s.versions[execution.ID] = execution.Version + 1
+    execution.Version++
+
+    return nil
+}
+
+type fileStore struct {
+    mu  sync.Mutex
+    dir string
+}
+
+// NewFileStore writes one JSON file per execution in dir, for local runs
+// and tests.
+func NewFileStore(dir string) Store {
+    return &fileStore{
+        dir: dir,
+    }
+}
+
+func (s *fileStore) Load(ctx context.Context, executionID string) (*Execution, error) {
+    data, err := ioutil.ReadFile(s.path(executionID))
+    if os.IsNotExist(err) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    execution := &Execution{}
+    if err = json.Unmarshal(data, execution); err != nil {
+        return nil, err
+    }
+
+    return execution, nil
+}
+
+// Save writes to a temporary file first so a crash never leaves a partial
+// checkpoint behind. The version is only checked within the process.
+func (s *fileStore) Save(ctx context.Context, execution *Execution) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    stored, err := s.Load(ctx, execution.ID)
+    if err != nil {
+        return err
+    }
+
+    var version int64
+    if stored != nil {
+        version = stored.Version
+    }
+    if version != execution.Version {
+        return conflict(execution)
+    }
+
+    data, err := marshalNext(execution)
+    if err != nil {
+        return err
+    }
+
+    if err = os.MkdirAll(s.dir, 0755); err != nil {
+        return err
+    }
+
+    tmp, err := ioutil.TempFile(s.dir, ".execution-*")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name())
+
+    if _, err = tmp.Write(data); err != nil {
+        tmp.Close()
+        return err
+    }
+
+    if err = tmp.Close(); err != nil {
+        return err
+    }
+
+    if err = os.Rename(tmp.Name(), s.path(execution.ID)); err != nil {
+        return err
+    }
+    execution.Version++
+
+    return nil
+}
+
+func (s *fileStore) path(executionID string) string {
+    return filepath.Join(s.dir, url.PathEscape(executionID)+".json")
+}
+
+// marshalNext encodes execution as saved, with the next version.
+func marshalNext(execution *Execution) ([]byte, error) {
+    next := *execution
+    next.Version++
+
+    return json.Marshal(&next)
+}
+
+func conflict(execution *Execution) error {
+    return ErrConflict.Newf("Execution %s was saved by another invocation since version %d", execution.ID, execution.Version)
+}
@@ -0,0 +1,366 @@
+package workflow
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "time"
+
+    jsoniter "github.com/json-iterator/go"
+    "github.com/onedaycat/errors"
+    "github.com/onedaycat/zamus/zamus"
+)
+
+var jsonen = jsoniter.ConfigCompatibleWithStandardLibrary
+
+var (
+    ErrInvalidRequest   = errors.DefBadRequest("InvalidWorkflowRequest", "Workflow request needs an executionId")
+    ErrNonDeterministic = errors.DefInternalError("NonDeterministicWorkflow", "Workflow steps do not match the checkpoints")
+    ErrStepNotCompleted = errors.DefInternalError("StepNotCompleted", "Workflow step has not completed")
+    ErrCheckpointFailed = errors.DefInternalError("CheckpointFailed", "Unable to save workflow checkpoint")
+    ErrConflict         = errors.DefInternalError("ExecutionConflict", "Workflow execution is run by another invocation")
+)
+
+type Status string
+
+const (
+    Running   Status = "running"
+    Failed    Status = "failed"
+    Completed Status = "completed"
+)
+
+// Request starts or resumes the execution with the given ID. Input is only
+// read when the execution starts; a resumed execution replays the stored
+// input.
+type Request struct {
+    ExecutionID string          `json:"executionId"`
+    Input       json.RawMessage `json:"input,omitempty"`
+}
+
+// Checkpoint is the recorded output of a completed step.
+type Checkpoint struct {
+    Step        string          `json:"step"`
+    Output      json.RawMessage `json:"output,omitempty"`
+    Attempts    int             `json:"attempts"`
+    CompletedAt time.Time       `json:"completedAt"`
+}
+
+// Execution is saved after every step. Version is the number of saves, which
+// Store.Save checks so two invocations never overwrite each other, and
+// LeaseUntil is when the invocation running the steps gives up on them.
+type Execution struct {
+    ID          string            `json:"id"`
+    Version     int64             `json:"version"`
+    LeaseUntil  time.Time         `json:"leaseUntil,omitempty"`
+    Status      Status            `json:"status"`
+    Input       json.RawMessage   `json:"input,omitempty"`
+    Checkpoints []*Checkpoint     `json:"checkpoints"`
+    Output      json.RawMessage   `json:"output,omitempty"`
+    Error       *errors.JSONError `json:"error,omitempty"`
+    UpdatedAt   time.Time         `json:"updatedAt"`
+}
+
+// Result is returned by every invocation of the workflow.
+type Result struct {
+    ExecutionID string          `json:"executionId"`
+    Status      Status          `json:"status"`
+    Output      json.RawMessage `json:"output,omitempty"`
+}
+
+type StepFunc func(ctx context.Context, state *State) (interface{}, error)
+
+// RetryPolicy retries a failing step within the same invocation. The delay
+// is multiplied by Multiplier after each attempt.
+type RetryPolicy struct {
+    Times       int
+    Delay       time.Duration
+    Multiplier  float64
+    IsRetryable func(err error) bool
+}
+
+type Step struct {
+    name  string
+    fn    StepFunc
+    retry *RetryPolicy
+}
+
+func (s *Step) SetRetry(policy *RetryPolicy) {
+    s.retry = policy
+}
+
+// Workflow is a zamus.Handler running its steps in order. The output of
+// each step is checkpointed to the Store, so an execution invoked again
+// with the same ID skips the completed steps and resumes where it stopped.
+type Workflow struct {
+    store Store
+    steps []*Step
+    lease time.Duration
+    now   func() time.Time
+    sleep func(ctx context.Context, d time.Duration) error
+}
+
+func New(store Store) *Workflow {
+    return &Workflow{
+        store: store,
+        lease: 15 * time.Minute,
+        now:   time.Now,
+        sleep: sleep,
+    }
+}
+
+// SetLease sets how long an invocation without deadline holds the
+// execution. An invocation with a deadline holds it until the deadline.
+// Other invocations of the same execution fail with ErrConflict meanwhile,
+// so a redelivered request never runs a step twice at once.
+func (w *Workflow) SetLease(lease time.Duration) {
+    w.lease = lease
+}
+
+// Step appends a step. Steps must keep their names and order between
+// deployments while executions are in flight, or resuming them fails with
+// ErrNonDeterministic.
+func (w *Workflow) Step(name string, fn StepFunc) *Step {
+    for _, s := range w.steps {
+        if s.name == name {
+            panic(fmt.Sprintf("workflow: step %s is already defined", name))
+        }
+    }
+
+    s := &Step{name: name, fn: fn}
+    w.steps = append(w.steps, s)
+
+    return s
+}
+
+func (w *Workflow) ParseSource(ctx context.Context, payload json.RawMessage) interface{} {
+    req := &Request{}
+    if err := jsonen.Unmarshal(payload, req); err != nil || req.ExecutionID == "" {
+        panic(ErrInvalidRequest.New())
+    }
+
+    return req
+}
+
+func (w *Workflow) ParseSources(ctx context.Context, payload json.RawMessage) interface{} {
+    panic(errors.InternalError("BatchInvokeNotAllowed", "Batch invoke not allowed"))
+}
+
+func (w *Workflow) BatchHandler(ctx context.Context, sources interface{}) (interface{}, error) {
+    panic(errors.InternalError("BatchInvokeNotAllowed", "Batch invoke not allowed"))
+}
+
+func (w *Workflow) Handler(ctx context.Context, source interface{}) (interface{}, error) {
+    req := source.(*Request)
+
+    execution, err := w.store.Load(ctx, req.ExecutionID)
+    if err != nil {
+        return nil, err
+    }
+
+    if execution == nil {
+        execution = &Execution{
+            ID:     req.ExecutionID,
+            Status: Running,
+            Input:  req.Input,
+        }
+    }
+
+    if execution.Status == Completed {
+        return result(execution), nil
+    }
+
+    if err = w.replay(execution); err != nil {
+        return nil, err
+    }
+
+    now := w.now()
+    if execution.LeaseUntil.After(now) {
+        return nil, ErrConflict.Newf("Execution %s is run by another invocation until %s", execution.ID, execution.LeaseUntil.Format(time.RFC3339))
+    }
+
+    execution.LeaseUntil = w.leaseUntil(ctx, now)
+    if err = w.store.Save(ctx, execution); err != nil {
+        return nil, checkpointFailed(err, "Unable to lease execution %s", execution.ID)
+    }
+
+    state := &State{execution: execution}
+    for _, step := range w.steps[len(execution.Checkpoints):] {
+        checkpoint, err := w.run(ctx, step, state)
+        if err != nil {
+            execution.Status = Failed
+            execution.Error = zamus.NewResultError(err)
+            execution.LeaseUntil = time.Time{}
+            execution.UpdatedAt = w.now()
+            if serr := w.store.Save(ctx, execution); serr != nil {
+                return nil, checkpointFailed(serr, "Unable to checkpoint the failure of step %s", step.name)
+            }
+
+            return nil, err
+        }
+
+        execution.Checkpoints = append(execution.Checkpoints, checkpoint)
+        execution.Status = Running
+        execution.Error = nil
+        execution.UpdatedAt = checkpoint.CompletedAt
+        if len(execution.Checkpoints) == len(w.steps) {
+            execution.Status = Completed
+            execution.Output = checkpoint.Output
+            execution.LeaseUntil = time.Time{}
+        }
+
+        if err = w.store.Save(ctx, execution); err != nil {
+            return nil, checkpointFailed(err, "Unable to checkpoint step %s", step.name)
+        }
+    }
+
+    return result(execution), nil
+}
+
+func (w *Workflow) leaseUntil(ctx context.Context, now time.Time) time.Time {
+    if deadline, ok := ctx.Deadline(); ok {
+        return deadline
+    }
+
+    return now.Add(w.lease)
+}
+
+// checkpointFailed keeps ErrConflict, which tells the execution moved on
+// without this invocation.
+func checkpointFailed(err error, format string, args ...interface{}) error {
+    if xerr, ok := err.(errors.Error); ok && ErrConflict.Is(xerr) {
+        return err
+    }
+
+    return ErrCheckpointFailed.Newf(format, args...).WithCause(err)
+}
+
+// replay checks the checkpoints were written by the same steps.
+func (w *Workflow) replay(execution *Execution) error {
+    if len(execution.Checkpoints) > len(w.steps) {
+        return ErrNonDeterministic.Newf("Execution %s has %d checkpoints but the workflow has %d steps", execution.ID, len(execution.Checkpoints), len(w.steps))
+    }
+
+    for i, checkpoint := range execution.Checkpoints {
+        if checkpoint.Step != w.steps[i].name {
+            return ErrNonDeterministic.Newf("Execution %s checkpointed step %d as %s, the workflow defines %s", execution.ID, i, checkpoint.Step, w.steps[i].name)
+        }
+    }
+
+    return nil
+}
+
+func (w *Workflow) run(ctx context.Context, step *Step, state *State) (*Checkpoint, error) {
+    var delay time.Duration
+    if step.retry != nil {
+        delay = step.retry.Delay
+    }
+
+    checkpoint := &Checkpoint{Step: step.name}
+    for {
+        checkpoint.Attempts++
+        output, err := w.call(ctx, step, state)
+        if err == nil {
+            checkpoint.Output = output
+            checkpoint.CompletedAt = w.now()
+            return checkpoint, nil
+        }
+
+        if !step.canRetry(checkpoint.Attempts, err) || ctx.Err() != nil {
+            return nil, err
+        }
+
+        if err = w.sleep(ctx, delay); err != nil {
+            return nil, err
+        }
+
+        if step.retry.Multiplier > 0 {
+            delay = time.Duration(float64(delay) * step.retry.Multiplier)
+        }
+    }
+}
+
+func (w *Workflow) call(ctx context.Context, step *Step, state *State) (output json.RawMessage, err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            err = zamus.PanicError(r)
+        }
+    }()
+
+    value, err := step.fn(ctx, state)
+    if err != nil {
+        return nil, err
+    }
+
+    if value == nil {
+        return nil, nil
+    }
+
+    return json.Marshal(value)
+}
+
+func (s *Step) canRetry(attempts int, err error) bool {
+    if s.retry == nil || attempts > s.retry.Times {
+        return false
+    }
+
+    return s.retry.IsRetryable == nil || s.retry.IsRetryable(err)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+    if d <= 0 {
+        return nil
+    }
+
+    timer := time.NewTimer(d)
+    defer timer.Stop()
+
+    select {
+    case <-timer.C:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+func result(execution *Execution) *Result {
+    return &Result{
+        ExecutionID: execution.ID,
+        Status:      execution.Status,
+        Output:      execution.Output,
+    }
+}
+
+// State gives a step the input of the execution and the outputs of the
+// steps before it, as decoded from their checkpoints so a resumed
+// execution sees the same values as the first run.
+type State struct {
+    execution *Execution
+}
+
+func (s *State) ExecutionID() string {
+    return s.execution.ID
+}
+
+func (s *State) Input(v interface{}) error {
+    if len(s.execution.Input) == 0 {
+        return nil
+    }
+
+    return jsonen.Unmarshal(s.execution.Input, v)
+}
+
+func (s *State) Output(step string, v interface{}) error {
+    for _, checkpoint := range s.execution.Checkpoints {
+        if checkpoint.Step != step {
+            continue
+        }
+
+        if len(checkpoint.Output) == 0 {
+            return nil
+        }
+
+        return jsonen.Unmarshal(checkpoint.Output, v)
+    }
+
+    return ErrStepNotCompleted.Newf("Step %s has not completed", step)
+}
@@ -0,0 +1,243 @@
+package workflow
+
+import (
+    "context"
+    "encoding/json"
+    "io/ioutil"
+    "os"
+    "testing"
+    "time"
+
+    "github.com/onedaycat/errors"
+    "github.com/onedaycat/zamus/zamus"
+    "github.com/stretchr/testify/require"
+)
+
+type order struct {
+    ID    string  `json:"id"`
+    Total float64 `json:"total"`
+}
+
+func TestWorkflowResume(t *testing.T) {
+    dir, err := ioutil.TempDir("", "workflow")
+    require.NoError(t, err)
+    defer os.RemoveAll(dir)
+
+    stores := map[string]Store{
+        "Memory": NewMemoryStore(),
+        "File":   NewFileStore(dir),
+    }
+
+    for name, store := range stores {
+        t.Run(name, func(t *testing.T) {
+            called := map[string]int{}
+            chargeFails := true
+
+            w := New(store)
+            w.Step("reserve", func(ctx context.Context, state *State) (interface{}, error) {
+                called["reserve"]++
+                o := &order{}
+                require.NoError(t, state.Input(o))
+                return "reservation-" + o.ID, nil
+            })
+            w.Step("charge", func(ctx context.Context, state *State) (interface{}, error) {
+                called["charge"]++
+                if chargeFails {
+                    return nil, errors.InternalError("PaymentDown", "payment is down")
+                }
+
+                var reservation string
+                require.NoError(t, state.Output("reserve", &reservation))
+                return map[string]string{"reservation": reservation, "charge": "c1"}, nil
+            })
+            w.Step("notify", func(ctx context.Context, state *State) (interface{}, error) {
+                called["notify"]++
+                return nil, nil
+            })
+            h := zamus.New(w)
+            payload := []byte(`{"executionId":"orders/1","input":{"id":"1","total":10}}`)
+
+            _, err := h.Invoke(context.Background(), payload)
+            require.Equal(t, "PaymentDown: payment is down", err.Error())
+
+            execution, err := store.Load(context.Background(), "orders/1")
+            require.NoError(t, err)
+            require.Equal(t, Failed, execution.Status)
+            require.Len(t, execution.Checkpoints, 1)
+            require.Equal(t, "PaymentDown", execution.Error.Code)
+
+            chargeFails = false
+            result, err := h.Invoke(context.Background(), []byte(`{"executionId":"orders/1","input":{"id":"changed"}}`))
+            require.NoError(t, err)
+            require.Equal(t, &Result{
+                ExecutionID: "orders/1",
+                Status:      Completed,
+            }, result)
+            require.Equal(t, map[string]int{"reserve": 1, "charge": 2, "notify": 1}, called)
+
+            execution, err = store.Load(context.Background(), "orders/1")
+            require.NoError(t, err)
+            require.Len(t, execution.Checkpoints, 3)
+            require.JSONEq(t, `{"reservation":"reservation-1","charge":"c1"}`, string(execution.Checkpoints[1].Output))
+            require.Equal(t, 1, execution.Checkpoints[1].Attempts)
+
+            t.Run("Completed execution is not run again", func(t *testing.T) {
+                _, err := h.Invoke(context.Background(), payload)
+
+                require.NoError(t, err)
+                require.Equal(t, map[string]int{"reserve": 1, "charge": 2, "notify": 1}, called)
+            })
+        })
+    }
+}
+
+func TestWorkflowRetryPolicy(t *testing.T) {
+    var delays []time.Duration
+    attempts := 0
+    w := New(NewMemoryStore())
+    w.sleep = func(ctx context.Context, d time.Duration) error {
+        delays = append(delays, d)
+        return nil
+    }
+    w.Step("flaky", func(ctx context.Context, state *State) (interface{}, error) {
+        attempts++
+        if attempts < 3 {
+            return nil, errors.InternalError("Busy", "busy")
+        }
+        return &order{ID: "1"}, nil
+    }).SetRetry(&RetryPolicy{Times: 3, Delay: 10 * time.Millisecond, Multiplier: 2})
+    w.Step("invalid", func(ctx context.Context, state *State) (interface{}, error) {
+        return nil, errors.BadRequest("Invalid", "invalid")
+    }).SetRetry(&RetryPolicy{Times: 3, IsRetryable: func(err error) bool {
+        return err.(errors.Error).GetType() != errors.BadRequestType
+    }})
+
+    h := zamus.New(w)
+    _, err := h.Invoke(context.Background(), []byte(`{"executionId":"1"}`))
+
+    require.Equal(t, "Invalid: invalid", err.Error())
+    require.Equal(t, 3, attempts)
+    require.Equal(t, []time.Duration{10 * time.Millisecond, 20 * time.Millisecond}, delays)
+
+    execution, _ := w.store.Load(context.Background(), "1")
+    require.Equal(t, 3, execution.Checkpoints[0].Attempts)
+    require.JSONEq(t, `{"id":"1","total":0}`, string(execution.Checkpoints[0].Output))
+}
+
+func TestWorkflowNonDeterministic(t *testing.T) {
+    store := NewMemoryStore()
+    require.NoError(t, store.Save(context.Background(), &Execution{
+        ID:          "1",
+        Status:      Failed,
+        Checkpoints: []*Checkpoint{{Step: "reserve"}},
+    }))
+
+    w := New(store)
+    w.Step("charge", func(ctx context.Context, state *State) (interface{}, error) {
+        return nil, nil
+    })
+    h := zamus.New(w)
+
+    _, err := h.Invoke(context.Background(), []byte(`{"executionId":"1"}`))
+    require.True(t, ErrNonDeterministic.Is(err.(errors.Error)))
+
+    _, err = h.Invoke(context.Background(), []byte(`{"input":{}}`))
+    require.True(t, ErrInvalidRequest.Is(err.(errors.Error)))
+
+    require.Panics(t, func() {
+        w.Step("charge", nil)
+    })
+}
+
+func TestWorkflowLease(t *testing.T) {
+    started := make(chan struct{})
+    release := make(chan struct{})
+    called := 0
+    w := New(NewMemoryStore())
+    w.Step("slow", func(ctx context.Context, state *State) (interface{}, error) {
+        called++
+        close(started)
+        <-release
+        return nil, nil
+    })
+    h := zamus.New(w)
+    payload := []byte(`{"executionId":"1"}`)
+
+    done := make(chan error)
+    go func() {
+        _, err := h.Invoke(context.Background(), payload)
+        done <- err
+    }()
+    <-started
+
+    _, err := h.Invoke(context.Background(), payload)
+    require.True(t, ErrConflict.Is(err.(errors.Error)))
+
+    close(release)
+    require.NoError(t, <-done)
+    require.Equal(t, 1, called)
+
+    execution, _ := w.store.Load(context.Background(), "1")
+    require.Equal(t, Completed, execution.Status)
+    require.True(t, execution.LeaseUntil.IsZero())
+
+    t.Run("Expired lease", func(t *testing.T) {
+        store := NewMemoryStore()
+        require.NoError(t, store.Save(context.Background(), &Execution{
+            ID:         "1",
+            Status:     Running,
+            LeaseUntil: time.Now().Add(-time.Second),
+        }))
+        w := New(store)
+        w.Step("a", func(ctx context.Context, state *State) (interface{}, error) {
+            return nil, nil
+        })
+
+        result, err := zamus.New(w).Invoke(context.Background(), payload)
+
+        require.NoError(t, err)
+        require.Equal(t, Completed, result.(*Result).Status)
+    })
+}
+
+func TestStoreConflict(t *testing.T) {
+    dir, err := ioutil.TempDir("", "workflow")
+    require.NoError(t, err)
+    defer os.RemoveAll(dir)
+
+    stores := map[string]Store{
+        "Memory": NewMemoryStore(),
+        "File":   NewFileStore(dir),
+    }
+
+    for name, store := range stores {
+        t.Run(name, func(t *testing.T) {
+            ctx := context.Background()
+            require.NoError(t, store.Save(ctx, &Execution{ID: "1", Status: Running}))
+
+            first, _ := store.Load(ctx, "1")
+            second, _ := store.Load(ctx, "1")
+            require.Equal(t, int64(1), first.Version)
+
+            require.NoError(t, store.Save(ctx, first))
+            require.Equal(t, int64(2), first.Version)
+
+            err := store.Save(ctx, second)
+            require.True(t, ErrConflict.Is(err.(errors.Error)))
+
+            err = store.Save(ctx, &Execution{ID: "1"})
+            require.True(t, ErrConflict.Is(err.(errors.Error)))
+        })
+    }
+}
+
+func TestStateOutput(t *testing.T) {
+    state := &State{execution: &Execution{
+        Checkpoints: []*Checkpoint{{Step: "a", Output: json.RawMessage(`{"id":"1"}`)}},
+    }}
+
+    o := &order{}
+    require.NoError(t, state.Output("a", o))
+    require.Equal(t, "1", o.ID)
+    require.True(t, ErrStepNotCompleted.Is(state.Output("b", o).(errors.Error)))
+}
@@ -0,0 +1,76 @@
+// Package zamustest provides a configurable zamus.Handler and logger.Logger
+// for the tests of middlewares and handlers built on zamus.
+package zamustest
+
+import (
+    "context"
+    "encoding/json"
+    "reflect"
+    "sync/atomic"
+)
+
+// Handler is a zamus.Handler whose behaviour is set by its fields. Without
+// NewSource the raw payload is the source and a batch is a
+// []json.RawMessage; with it the payload is decoded into NewSource() and a
+// batch into a slice of that type. Parse errors panic, as in source
+// handlers. Nil funcs return nil, nil.
+type Handler struct {
+    HandlerFunc      func(ctx context.Context, source interface{}) (interface{}, error)
+    BatchHandlerFunc func(ctx context.Context, sources interface{}) (interface{}, error)
+    NewSource        func() interface{}
+    calls            int32
+}
+
+func (h *Handler) ParseSource(ctx context.Context, payload json.RawMessage) interface{} {
+    if h.NewSource == nil {
+        return payload
+    }
+
+    source := h.NewSource()
+    if err := json.Unmarshal(payload, source); err != nil {
+        panic(err)
+    }
+
+    return source
+}
+
+func (h *Handler) ParseSources(ctx context.Context, payload json.RawMessage) interface{} {
+    sourceType := reflect.TypeOf(json.RawMessage{})
+    if h.NewSource != nil {
+        sourceType = reflect.TypeOf(h.NewSource())
+    }
+
+    sources := reflect.New(reflect.SliceOf(sourceType))
+    if err := json.Unmarshal(payload, sources.Interface()); err != nil {
+        panic(err)
+    }
+
+    return sources.Elem().Interface()
+}
+
+func (h *Handler) Handler(ctx context.Context, source interface{}) (interface{}, error) {
+    atomic.AddInt32(&h.calls, 1)
+    if h.HandlerFunc == nil {
+        return nil, nil
+    }
+
+    return h.HandlerFunc(ctx, source)
+}
+
+func (h *Handler) BatchHandler(ctx context.Context, sources interface{}) (interface{}, error) {
+    atomic.AddInt32(&h.calls, 1)
+    if h.BatchHandlerFunc == nil {
+        return nil, nil
+    }
+
+    return h.BatchHandlerFunc(ctx, sources)
+}
+
+// Calls counts the calls to Handler and BatchHandler.
+func (h *Handler) Calls() int {
+    return int(atomic.LoadInt32(&h.calls))
+}
+
+func (h *Handler) ResetCalls() {
+    atomic.StoreInt32(&h.calls, 0)
+}
@@ -0,0 +1,67 @@
+package zamustest
+
+import (
+    "sync"
+
+    "github.com/onedaycat/zamus/logger"
+)
+
+// Line is one call to the Logger. Msg is the error message for Error and
+// Panic.
+type Line struct {
+    Level logger.Level
+    Msg   string
+    Data  []interface{}
+}
+
+// Logger is a logger.Logger keeping every line in memory. It is safe for
+// concurrent use.
+type Logger struct {
+    mu    sync.Mutex
+    lines []Line
+}
+
+func (l *Logger) SetLevel(level logger.Level) {}
+func (l *Logger) Pretty(pretty bool)          {}
+
+func (l *Logger) Debug(msg string, data ...interface{}) {
+    l.add(logger.DebugLevel, msg, data)
+}
+
+func (l *Logger) Info(msg string, data ...interface{}) {
+    l.add(logger.InfoLevel, msg, data)
+}
+
+func (l *Logger) Warn(msg string, data ...interface{}) {
+    l.add(logger.WarnLevel, msg, data)
+}
+
+func (l *Logger) Error(err error, data ...interface{}) {
+    l.add(logger.ErrorLevel, err.Error(), data)
+}
+
+func (l *Logger) Panic(err error, data ...interface{}) {
+    l.add(logger.PanicLevel, err.Error(), data)
+}
+
+func (l *Logger) add(level logger.Level, msg string, data []interface{}) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    l.lines = append(l.lines, Line{Level: level, Msg: msg, Data: data})
+}
+
+// Lines returns a copy of the lines logged so far.
+func (l *Logger) Lines() []Line {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    return append([]Line(nil), l.lines...)
+}
+
+func (l *Logger) Reset() {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    l.lines = nil
+}